@@ -10,9 +10,9 @@ import (
 
 // ModbusBase implements base server interface
 type ModbusBaseServer struct {
-	Host string      // Host Name/IP
-	Port string      // Server port
-	Data *ModbusData // Modbus Data
+	Host string          // Host Name/IP
+	Port string          // Server port
+	Data ModbusDataStore // Modbus Data
 }
 
 type IModbusBaseServer interface {
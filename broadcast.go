@@ -0,0 +1,103 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// Unit ID of broadcast request on serial line
+const BroadcastID byte = 0
+
+// Checks that request is broadcast, ModbusTCP has no broadcast
+func isBroadcast(mp *ModbusPacket) bool {
+	return mp.TypeProtocol != ModbusTCP && mp.GetDevID() == BroadcastID
+}
+
+// Checks that function can be broadcast, only write functions are allowed
+func isBroadcastFunction(fc ModbusFunctionCode) bool {
+	switch fc {
+	case FcForceSingleCoil, FcPresetSingleRegister, FcForceMultipleCoils, FcPresetMultipleRegisters,
+		FcWriteFileRecord, FcMaskWriteRegister:
+		return true
+	default:
+		return false
+	}
+}
+
+// Copy of request addressed to unit ID
+func withDevID(mp *ModbusPacket, devID byte) *ModbusPacket {
+	request := *mp
+	request.PDU = append([]byte(nil), mp.PDU...)
+	request.aPDU = request.PDU[mp.TypeProtocol.Offset():]
+	request.SetDevID(devID)
+	return &request
+}
+
+// Apply broadcast request to data of all units, answers are not sent
+func (srv *ModbusServer) serveBroadcast(ctx context.Context, request *ModbusPacket) {
+	fc := request.GetFunctionCode()
+	if !isBroadcastFunction(fc) {
+		log.Printf("Function %s(0x%x) can't be broadcast\n", fc, byte(fc))
+		return
+	}
+	if srv.Units == nil {
+		if _, err := srv.handler().ServeModbus(ctx, request); err != nil {
+			log.Println("Error handle broadcast request:", err.Error())
+		}
+		return
+	}
+	ids := make([]byte, 0, len(srv.Units))
+	for id := range srv.Units {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		if _, err := srv.handler().ServeModbus(ctx, withDevID(request, id)); err != nil {
+			log.Println("Error handle broadcast request for unit", id, ":", err.Error())
+		}
+	}
+}
+
+// Send broadcast request, request must have unit ID 0. Answer isn't waited,
+// next request is sent after TurnaroundDelay.
+func (mc *ModbusClient) SendBroadcast(mp *ModbusPacket) error {
+	return mc.SendBroadcastContext(context.Background(), mp)
+}
+
+// Send broadcast request, request is interrupted when ctx is done
+func (mc *ModbusClient) SendBroadcastContext(ctx context.Context, mp *ModbusPacket) error {
+	if mc.TypeProtocol == ModbusTCP {
+		return errors.New("Broadcast is not supported by ModbusTCP")
+	}
+	if mp.GetDevID() != BroadcastID {
+		return fmt.Errorf("Unit ID %d of broadcast request must be %d", mp.GetDevID(), BroadcastID)
+	}
+	if fc := mp.GetFunctionCode(); !isBroadcastFunction(fc) {
+		return fmt.Errorf("Function %s(0x%x) can't be broadcast", fc, byte(fc))
+	}
+	return mc.sendNoAnswer(ctx, mp, mc.TurnaroundDelay)
+}
+
+// Send broadcast request with raw PDU: function code and data
+func (mc *ModbusClient) BroadcastRawPDU(fc ModbusFunctionCode, data []byte) error {
+	return mc.BroadcastRawPDUContext(context.Background(), fc, data)
+}
+
+// Send broadcast request with raw PDU, request is interrupted when ctx is
+// done
+func (mc *ModbusClient) BroadcastRawPDUContext(ctx context.Context, fc ModbusFunctionCode, data []byte) error {
+	if len(data) > MaxPDUDataSize {
+		return ErrFrameTooLong
+	}
+	request := &ModbusPacket{}
+	request.Init(mc.TypeProtocol)
+	request.buildRawPDU(BroadcastID, fc, data)
+	return mc.SendBroadcastContext(ctx, request)
+}
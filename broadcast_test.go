@@ -0,0 +1,97 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+type testserveBroadcastpair struct {
+	fc   ModbusFunctionCode
+	data []byte
+}
+
+var testsserveBroadcast = []testserveBroadcastpair{
+	{FcPresetSingleRegister, []byte{0x0, 0x1, 0x0, 0x5}},
+	{FcReadHoldingRegisters, []byte{0x0, 0x1, 0x0, 0x1}},
+}
+
+func TestModbusServer_serveBroadcast(t *testing.T) {
+	md := new(ModbusData)
+	md.Init(10, 10, 10, 10)
+	srv := &ModbusServer{}
+	srv.Data = md
+	for _, pair := range testsserveBroadcast {
+		req := &ModbusPacket{}
+		req.Init(ModbusRTUviaTCP)
+		req.buildRawPDU(BroadcastID, pair.fc, pair.data)
+		if answ := srv.serveRequest(context.Background(), req); answ != nil {
+			t.Error("For", pair.fc, "expected nil, got", answ)
+		}
+	}
+	if res, _ := md.ReadHoldingRegisters(1, 1); res[0] != 5 {
+		t.Error("Expected 5, got", res)
+	}
+	if counters := srv.GetCounters(); counters.ServerMessages != 2 || counters.ServerNoResponses != 2 {
+		t.Error("Expected 2 messages without response, got", counters)
+	}
+
+	// Unit ID 0 isn't broadcast for ModbusTCP
+	req := &ModbusPacket{}
+	req.Init(ModbusTCP)
+	req.buildRawPDU(BroadcastID, FcReadHoldingRegisters, []byte{0x0, 0x1, 0x0, 0x1})
+	if answ := srv.serveRequest(context.Background(), req); answ == nil {
+		t.Error("Expected answer, got nil")
+	}
+}
+
+func TestModbusClient_Broadcast(t *testing.T) {
+	srv := NewServer("127.0.0.1", "0", ModbusRTUviaTCP, nil)
+	for id := byte(1); id <= 2; id++ {
+		md := new(ModbusData)
+		md.Init(10, 10, 10, 10)
+		srv.AddUnit(id, md)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer srv.Stop()
+	_, port, _ := net.SplitHostPort(srv.ln.Addr().String())
+	cl, err := NewClient(port, "127.0.0.1", ModbusRTUviaTCP, 1)
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer cl.Close()
+
+	cl.TurnaroundDelay = 50 * time.Millisecond
+	start := time.Now()
+	if err = cl.BroadcastRawPDU(FcPresetSingleRegister, []byte{0x0, 0x3, 0x0, 0x7}); err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	if d := time.Since(start); d < cl.TurnaroundDelay {
+		t.Error("Expected delay", cl.TurnaroundDelay, "got", d)
+	}
+	for id := byte(1); id <= 2; id++ {
+		cl.DevID = id
+		if res, err := cl.ReadHoldingRegisters(3, 1); err != nil || len(res) != 1 || res[0] != 7 {
+			t.Error("For unit", id, "expected [7], got", res, err)
+		}
+	}
+
+	if err = cl.BroadcastRawPDU(FcReadHoldingRegisters, []byte{0x0, 0x3, 0x0, 0x1}); err == nil {
+		t.Error("Expected error, got nil")
+	}
+	request := buildRequest(0, ModbusRTUviaTCP, 1, FcPresetSingleRegister, 3, 8)
+	if err = cl.SendBroadcast(request); err == nil {
+		t.Error("Expected error, got nil")
+	}
+	cl.TypeProtocol = ModbusTCP
+	if err = cl.BroadcastRawPDU(FcPresetSingleRegister, []byte{0x0, 0x3, 0x0, 0x7}); err == nil {
+		t.Error("Expected error, got nil")
+	}
+}
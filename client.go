@@ -5,29 +5,131 @@
 package modbus
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"net"
+	"sync"
+	"time"
 )
 
-// ModbusClient implements client interface
+// Default settings of client
+const (
+	ClientDefaultTimeout         = time.Second            // Response timeout
+	ClientDefaultTurnaroundDelay = 100 * time.Millisecond // Delay after broadcast request
+	UDPDefaultRetransmits        = 2                      // Retransmissions of request for UDP
+)
+
+// Input is flushed until it is silent during this interval
+const clientFlushInterval = 50 * time.Millisecond
+
+// ModbusClient implements client interface. It is safe for concurrent
+// use, requests are sent one by one.
 type ModbusClient struct {
 	ModbusBaseClient
-	DevID         byte
-	TypeProtocol  ModbusTypeProtocol // Type Modbus Protocol
-	Conn          net.Conn           // Connection
-	TranscationId uint16             // for ModbusTCP
+	DevID           byte
+	TypeProtocol    ModbusTypeProtocol // Type Modbus Protocol
+	Conn            net.Conn           // Connection
+	TranscationId   uint16             // for ModbusTCP
+	Serial          *SerialConfig      // Serial line settings, nil for TCP
+	Timeout         time.Duration      // Response timeout, 0 waits answer forever
+	TurnaroundDelay time.Duration      // Delay after broadcast request before next request
+	Retransmits     int                // Retransmissions of request for UDP
+	Reconnect       *ReconnectPolicy   // Reconnect policy, nil disables reconnect
+	Retry           *RetryPolicy       // Retry policy, nil disables retries
+	OnStateChange   ConnStateHook      // Hook for connection state changes
+	fr              frameReader        // Reader of answer frames
+	dial            dialFunc           // Establish connection
+	broken          bool               // Connection is broken by I/O error
+	flush           bool               // Late answers may be queued, input is flushed before next request
+	closed          bool               // Client is closed
+	mu              sync.Mutex         // Serializes requests
+	connMu          sync.Mutex         // Guards replacing and closing of connection
+	tidMu           sync.Mutex         // Guards transaction ID
+	pipe            *pipeline          // Pipeline of outstanding requests
 }
 
 // NewClient function initializate new instance of ModbusClient
 func NewClient(port, host string, mbprotocol ModbusTypeProtocol, devID byte) (*ModbusClient, error) {
-	var err error
-	mc := &ModbusClient{TypeProtocol: mbprotocol, DevID: devID}
+	mc := &ModbusClient{
+		TypeProtocol:    mbprotocol,
+		DevID:           devID,
+		Timeout:         ClientDefaultTimeout,
+		TurnaroundDelay: ClientDefaultTurnaroundDelay}
+	mc.Host = host
+	mc.Port = port
+	mc.dial = func(ctx context.Context) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", mc.String())
+	}
+	return mc, mc.connect(context.Background())
+}
+
+// NewUDPClient function initializate new instance of ModbusClient
+// sending requests in datagrams
+func NewUDPClient(port, host string, mbprotocol ModbusTypeProtocol, devID byte) (*ModbusClient, error) {
+	mc := &ModbusClient{
+		TypeProtocol:    mbprotocol,
+		DevID:           devID,
+		Timeout:         ClientDefaultTimeout,
+		TurnaroundDelay: ClientDefaultTurnaroundDelay,
+		Retransmits:     UDPDefaultRetransmits}
 	mc.Host = host
 	mc.Port = port
-	mc.Conn, err = net.Dial("tcp", mc.String())
-	return mc, err
+	mc.dial = func(ctx context.Context) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "udp", mc.String())
+	}
+	return mc, mc.connect(context.Background())
+}
+
+// NewTLSClient function initializate new instance of ModbusClient
+// for Modbus/TCP Security, config must contain client certificate
+func NewTLSClient(port, host string, mbprotocol ModbusTypeProtocol, devID byte, config *tls.Config) (*ModbusClient, error) {
+	mc := &ModbusClient{
+		TypeProtocol:    mbprotocol,
+		DevID:           devID,
+		Timeout:         ClientDefaultTimeout,
+		TurnaroundDelay: ClientDefaultTurnaroundDelay}
+	mc.Host = host
+	mc.Port = port
+	d := &tls.Dialer{Config: securityConfig(config, false)}
+	mc.dial = func(ctx context.Context) (net.Conn, error) {
+		return d.DialContext(ctx, "tcp", mc.String())
+	}
+	return mc, mc.connect(context.Background())
+}
+
+// NewSerialClient function initializate new instance of ModbusClient
+// working over serial line. ModbusRTUviaTCP framing is used for Modbus RTU.
+func NewSerialClient(cfg SerialConfig, mbprotocol ModbusTypeProtocol, devID byte) (*ModbusClient, error) {
+	mc := &ModbusClient{
+		TypeProtocol:    mbprotocol,
+		DevID:           devID,
+		Timeout:         ClientDefaultTimeout,
+		TurnaroundDelay: ClientDefaultTurnaroundDelay}
+	mc.Serial = &cfg
+	mc.dial = func(ctx context.Context) (net.Conn, error) {
+		port, err := OpenSerial(cfg)
+		if err != nil {
+			return nil, err
+		}
+		mc.Serial = &port.Config
+		return port, nil
+	}
+	return mc, mc.connect(context.Background())
+}
+
+// Return string with host ip/name and port or serial line settings
+func (mc *ModbusClient) String() string {
+	if mc.Serial != nil {
+		return mc.Serial.String()
+	}
+	return mc.ModbusBaseClient.String()
 }
 
 // Read Answer from Slave device (Server)
@@ -41,8 +143,11 @@ func (mc *ModbusClient) ReadAnswer() (*ModbusPacket, error) {
 		mc.Conn.RemoteAddr(),
 		mc.Conn.LocalAddr())
 
+	if mc.fr == nil {
+		mc.fr = newFrameReader(mc.Conn, mc.TypeProtocol, true)
+	}
 	// Read the incoming connection into the buffer.
-	answer.Length, err = mc.Conn.Read(answer.PDU)
+	err = mc.fr.ReadFrame(answer)
 	if err != nil {
 		log.Println("Error reading:", err.Error())
 	}
@@ -52,131 +157,584 @@ func (mc *ModbusClient) ReadAnswer() (*ModbusPacket, error) {
 
 // Send Request to Slave device (Server) and return Answer from it
 func (mc *ModbusClient) SendRequest(mp *ModbusPacket) (*ModbusPacket, error) {
-	var err error
+	return mc.SendRequestContext(context.Background(), mp)
+}
+
+// Send Request to Slave device (Server) and return Answer from it.
+// Waiting of answer is limited by response timeout and ctx deadline,
+// request is interrupted when ctx is canceled. Failed request is
+// repeated according to retry policy.
+func (mc *ModbusClient) SendRequestContext(ctx context.Context, mp *ModbusPacket) (*ModbusPacket, error) {
+	if mc.pipe != nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return mc.sendWithRetry(ctx, mp, mc.pipe.sendRequest)
+	}
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.sendWithRetry(ctx, mp, mc.sendRequest)
+}
+
+// Send one attempt of request and return answer
+func (mc *ModbusClient) sendRequest(ctx context.Context, mp *ModbusPacket) (*ModbusPacket, error) {
+	var (
+		err    error
+		answer *ModbusPacket
+	)
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err = mc.checkConn(); err == ErrClientClosed {
+		return nil, err
+	}
+	// Redial connection broken by previous request or failed first dial
+	if mc.broken && mc.Reconnect != nil {
+		if err = mc.reconnect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if err = mc.checkConn(); err != nil {
+		return nil, err
+	}
+	stop := mc.watchContext(ctx)
+	defer stop()
 
+	if mc.isDatagram() {
+		answer, err = mc.sendDatagram(ctx, mp)
+	} else {
+		answer, err = mc.sendStream(ctx, mp)
+	}
+	err = contextError(ctx, err)
+	if mc.Reconnect != nil && (isConnError(err) || mc.staleStream()) {
+		mc.disconnect(err)
+	}
+	return answer, err
+}
+
+// Checks that late answer may come to network stream connection. Such
+// connection is redialed when reconnect policy is set, otherwise input
+// is flushed. Serial line can't drop late answer, so it is always flushed.
+func (mc *ModbusClient) staleStream() bool {
+	return mc.flush && mc.Serial == nil && !mc.isDatagram()
+}
+
+// Send request which has no answer, next request is not sent until delay
+// is expired
+func (mc *ModbusClient) sendNoAnswer(ctx context.Context, mp *ModbusPacket, delay time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if mc.pipe != nil {
+		mc.pipe.wmu.Lock()
+		defer mc.pipe.wmu.Unlock()
+	} else {
+		mc.mu.Lock()
+		defer mc.mu.Unlock()
+	}
+	if err := mc.checkConn(); err != nil {
+		return err
+	}
+	log.Println("Send request to", mc)
+	mc.Conn.SetWriteDeadline(mc.deadline(ctx))
+	_, err := mc.Conn.Write(mp.GetFrame())
+	if err != nil {
+		log.Println("Error connect:", err.Error())
+		return contextError(ctx, err)
+	}
+	if delay > 0 {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Send request to stream connection or serial line and read answer
+func (mc *ModbusClient) sendStream(ctx context.Context, mp *ModbusPacket) (*ModbusPacket, error) {
+	if mc.flush {
+		mc.flushInput()
+	}
 	log.Println("Send request to", mc)
-	_, err = mc.Conn.Write(mp.PDU[:mp.GetPDULength()])
+	mc.Conn.SetDeadline(mc.deadline(ctx))
+	_, err := mc.Conn.Write(mp.GetFrame())
 	if err != nil {
 		log.Println("Error connect:", err.Error())
 		return nil, err
 	}
 
-	return mc.ReadAnswer()
+	for {
+		answer, err := mc.ReadAnswer()
+		if err != nil {
+			// ModbusTCP frame reader keeps partial answer at timeout, late
+			// answer is dropped by transaction ID. In other cases rest of
+			// answer is flushed before next request.
+			if mc.TypeProtocol != ModbusTCP || !isTimeout(err) {
+				mc.flush = true
+			}
+			return nil, err
+		}
+		if !answer.IsChecksumGood() {
+			mc.flush = true
+			return nil, ErrBadChecksum
+		}
+		// Late answer to previous request
+		if mc.TypeProtocol == ModbusTCP && answer.GetTransactionId() != mp.GetTransactionId() {
+			log.Println("Drop answer for transaction", answer.GetTransactionId())
+			continue
+		}
+		// Without transaction ID late answer is detected by data
+		if mc.TypeProtocol != ModbusTCP && !answerMatches(mp, answer) {
+			log.Println("Drop answer which doesn't match request")
+			continue
+		}
+		return answer, nil
+	}
+}
+
+// Checks that answer is answer to request: unit ID and function code must
+// match request, exception answer has function code with high bit set
+func checkAnswer(request, answer *ModbusPacket) error {
+	if answer.GetDevID() != request.GetDevID() {
+		return &MismatchError{ErrUnitIdMismatch, request.GetDevID(), answer.GetDevID()}
+	}
+	if byte(answer.GetFunctionCode())&^0x80 != byte(request.GetFunctionCode()) {
+		return &MismatchError{ErrFunctionCodeMismatch, byte(request.GetFunctionCode()), byte(answer.GetFunctionCode())}
+	}
+	return nil
+}
+
+// Checks that data of answer fits request: byte count of read answer
+// matches requested quantity, write answer echoes request. It detects late
+// answer to other request with the same function code when there is no
+// transaction ID. Answers of other unit ID or function and exceptions are
+// left to checkAnswer.
+func answerMatches(request, answer *ModbusPacket) bool {
+	if checkAnswer(request, answer) != nil || answer.IsException() {
+		return true
+	}
+	req := request.GetRawPDU()
+	pdu := answer.GetRawPDU()
+	_, cnt := request.GetFunctionParameters()
+	switch request.GetFunctionCode() {
+	case FcReadCoilStatus, FcReadDescreteInputs:
+		return len(pdu) > 1 && int(pdu[1]) == coilsByteCnt(cnt)
+	case FcReadHoldingRegisters, FcReadInputRegisters, FcReadWriteMultipleRegisters:
+		return len(pdu) > 1 && int(pdu[1]) == registersByteCnt(cnt)
+	case FcForceSingleCoil, FcPresetSingleRegister, FcMaskWriteRegister:
+		return bytes.Equal(pdu, req)
+	case FcForceMultipleCoils, FcPresetMultipleRegisters:
+		// Address and quantity
+		return len(pdu) == 5 && len(req) >= 5 && bytes.Equal(pdu[1:5], req[1:5])
+	default:
+		return true
+	}
+}
+
+// Replace error caused by done ctx with ctx error
+func contextError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	// Connection deadline may expire a bit earlier than ctx
+	if d, ok := ctx.Deadline(); ok && isTimeout(err) && !time.Now().Before(d) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// Deadline for answer: response timeout limited by ctx deadline,
+// zero time if there is no limit
+func (mc *ModbusClient) deadline(ctx context.Context) time.Time {
+	var d time.Time
+	if mc.Timeout > 0 {
+		d = time.Now().Add(mc.Timeout)
+	}
+	if cd, ok := ctx.Deadline(); ok && (d.IsZero() || cd.Before(d)) {
+		d = cd
+	}
+	return d
+}
+
+// Interrupt I/O on connection when ctx is canceled, returned function
+// stops watching
+func (mc *ModbusClient) watchContext(ctx context.Context) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			// Deadline in the past unblocks reading and writing
+			mc.Conn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// Checks that client sends requests in datagrams
+func (mc *ModbusClient) isDatagram() bool {
+	_, ok := mc.Conn.(net.PacketConn)
+	return ok
+}
+
+// Send request datagram and wait answer, request is retransmitted if
+// answer is not received during timeout
+func (mc *ModbusClient) sendDatagram(ctx context.Context, mp *ModbusPacket) (*ModbusPacket, error) {
+	var (
+		err    error
+		answer *ModbusPacket
+	)
+	if mc.flush {
+		mc.flushInput()
+	}
+	for attempt := 0; attempt <= mc.Retransmits; attempt++ {
+		log.Println("Send request to", mc)
+		mc.Conn.SetWriteDeadline(mc.deadline(ctx))
+		_, err = mc.Conn.Write(mp.GetFrame())
+		if err != nil {
+			log.Println("Error connect:", err.Error())
+			return nil, err
+		}
+		mc.Conn.SetReadDeadline(mc.deadline(ctx))
+		answer, err = mc.readDatagram(mp)
+		if err == nil || !isTimeout(err) || ctx.Err() != nil {
+			return answer, err
+		}
+		// Answer may come late, without transaction ID it can't be
+		// distinguished from answer to next request
+		if mc.TypeProtocol != ModbusTCP {
+			mc.flush = true
+		}
+		log.Println("Answer timeout, attempt", attempt+1)
+	}
+	return nil, err
+}
+
+// Read answer datagram. Datagrams which are not valid frames or answers
+// to other requests are dropped.
+func (mc *ModbusClient) readDatagram(request *ModbusPacket) (*ModbusPacket, error) {
+	// Enough for hex encoded ModbusASCII frame
+	buf := make([]byte, 2*mc.TypeProtocol.MaxSize()+3)
+	for {
+		n, err := mc.Conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		answer := &ModbusPacket{isAnswer: true}
+		answer.Init(mc.TypeProtocol)
+		fr := newFrameReader(bytes.NewReader(buf[:n]), mc.TypeProtocol, true)
+		if err = fr.ReadFrame(answer); err != nil {
+			log.Println("Drop datagram:", err.Error())
+			continue
+		}
+		if !answer.IsChecksumGood() {
+			log.Println("Drop datagram:", ErrBadChecksum.Error())
+			continue
+		}
+		if answer.GetTransactionId() != request.GetTransactionId() {
+			log.Println("Drop answer for transaction", answer.GetTransactionId())
+			continue
+		}
+		if mc.TypeProtocol != ModbusTCP && !answerMatches(request, answer) {
+			log.Println("Drop answer which doesn't match request")
+			continue
+		}
+		return answer, nil
+	}
+}
+
+// Drop input queued by late answers, input is read until it is silent
+// during clientFlushInterval
+func (mc *ModbusClient) flushInput() {
+	mc.flush = false
+	mc.fr = nil
+	// Enough for hex encoded ModbusASCII frame
+	buf := make([]byte, 2*mc.TypeProtocol.MaxSize()+3)
+	for {
+		mc.Conn.SetReadDeadline(time.Now().Add(clientFlushInterval))
+		n, err := mc.Conn.Read(buf)
+		if err != nil {
+			break
+		}
+		log.Println("Drop", n, "bytes of late answer")
+	}
+	mc.Conn.SetReadDeadline(time.Time{})
 }
 
 // Send Request ReadHoldingRegisters
 func (mc *ModbusClient) ReadHoldingRegisters(addr, cnt uint16) ([]uint16, error) {
+	return mc.ReadHoldingRegistersContext(context.Background(), addr, cnt)
+}
+
+// Send Request ReadHoldingRegisters, request is interrupted when ctx is done
+func (mc *ModbusClient) ReadHoldingRegistersContext(ctx context.Context, addr, cnt uint16) ([]uint16, error) {
+	if err := checkQuantity(cnt, MaxReadRegisters); err != nil {
+		return nil, err
+	}
 	request := buildRequest(mc.GetTransactionId(), mc.TypeProtocol, mc.DevID, FcReadHoldingRegisters, addr, cnt)
-	answer, err := mc.SendRequest(request)
+	answer, err := mc.SendRequestContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if err = answer.GetException(); err != nil {
+		return nil, err
+	}
+	data, err := answerData(answer, registersByteCnt(cnt))
 	if err != nil {
 		return nil, err
 	}
-	_, data := answer.GetData()
 	return byteArrToWordArr(data), nil
 }
 
 // Send Request ReadInputRegisters
 func (mc *ModbusClient) ReadInputRegisters(addr, cnt uint16) ([]uint16, error) {
+	return mc.ReadInputRegistersContext(context.Background(), addr, cnt)
+}
+
+// Send Request ReadInputRegisters, request is interrupted when ctx is done
+func (mc *ModbusClient) ReadInputRegistersContext(ctx context.Context, addr, cnt uint16) ([]uint16, error) {
+	if err := checkQuantity(cnt, MaxReadRegisters); err != nil {
+		return nil, err
+	}
 	request := buildRequest(mc.GetTransactionId(), mc.TypeProtocol, mc.DevID, FcReadInputRegisters, addr, cnt)
-	answer, err := mc.SendRequest(request)
+	answer, err := mc.SendRequestContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if err = answer.GetException(); err != nil {
+		return nil, err
+	}
+	data, err := answerData(answer, registersByteCnt(cnt))
 	if err != nil {
 		return nil, err
 	}
-	_, data := answer.GetData()
 	return byteArrToWordArr(data), nil
 }
 
 // Send Request PresetSingleRegister
 func (mc *ModbusClient) PresetSingleRegister(addr, value uint16) error {
-	request := buildRequest(mc.GetTransactionId(), mc.TypeProtocol, mc.DevID, FcReadInputRegisters, addr, value)
-	answer, err := mc.SendRequest(request)
+	return mc.PresetSingleRegisterContext(context.Background(), addr, value)
+}
+
+// Send Request PresetSingleRegister, request is interrupted when ctx is done
+func (mc *ModbusClient) PresetSingleRegisterContext(ctx context.Context, addr, value uint16) error {
+	request := buildRequest(mc.GetTransactionId(), mc.TypeProtocol, mc.DevID, FcPresetSingleRegister, addr, value)
+	answer, err := mc.SendRequestContext(ctx, request)
 	if err != nil {
 		return err
 	}
-	if byte(answer.GetFunctionCode())&0x80 > 0 {
-		return fmt.Errorf("Error code %x", answer.GetErrorCode())
-	}
-	return nil
+	return answer.GetException()
 }
 
 // Send Request ReadCoilStatus
 func (mc *ModbusClient) ReadCoilStatus(addr, cnt uint16) ([]bool, error) {
+	return mc.ReadCoilStatusContext(context.Background(), addr, cnt)
+}
+
+// Send Request ReadCoilStatus, request is interrupted when ctx is done
+func (mc *ModbusClient) ReadCoilStatusContext(ctx context.Context, addr, cnt uint16) ([]bool, error) {
+	if err := checkQuantity(cnt, MaxReadCoils); err != nil {
+		return nil, err
+	}
 	request := buildRequest(mc.GetTransactionId(), mc.TypeProtocol, mc.DevID, FcReadCoilStatus, addr, cnt)
-	answer, err := mc.SendRequest(request)
+	answer, err := mc.SendRequestContext(ctx, request)
 	if err != nil {
 		return nil, err
 	}
-	_, data := answer.GetData()
-	return byteArrToBoolArr(data, byte(cnt)), nil
-
+	if err = answer.GetException(); err != nil {
+		return nil, err
+	}
+	data, err := answerData(answer, coilsByteCnt(cnt))
+	if err != nil {
+		return nil, err
+	}
+	return byteArrToBoolArr(data, cnt), nil
 }
 
-// Send Request ReadCoilStatus
+// Send Request ReadDescreteInputs
 func (mc *ModbusClient) ReadDescreteInputs(addr, cnt uint16) ([]bool, error) {
+	return mc.ReadDescreteInputsContext(context.Background(), addr, cnt)
+}
+
+// Send Request ReadDescreteInputs, request is interrupted when ctx is done
+func (mc *ModbusClient) ReadDescreteInputsContext(ctx context.Context, addr, cnt uint16) ([]bool, error) {
+	if err := checkQuantity(cnt, MaxReadCoils); err != nil {
+		return nil, err
+	}
 	request := buildRequest(mc.GetTransactionId(), mc.TypeProtocol, mc.DevID, FcReadDescreteInputs, addr, cnt)
-	answer, err := mc.SendRequest(request)
+	answer, err := mc.SendRequestContext(ctx, request)
 	if err != nil {
 		return nil, err
 	}
-	_, data := answer.GetData()
-	return byteArrToBoolArr(data, byte(cnt)), nil
+	if err = answer.GetException(); err != nil {
+		return nil, err
+	}
+	data, err := answerData(answer, coilsByteCnt(cnt))
+	if err != nil {
+		return nil, err
+	}
+	return byteArrToBoolArr(data, cnt), nil
 }
 
 // Send Request ForceSingleCoil
 func (mc *ModbusClient) ForceSingleCoil(addr uint16, value bool) error {
+	return mc.ForceSingleCoilContext(context.Background(), addr, value)
+}
+
+// Send Request ForceSingleCoil, request is interrupted when ctx is done
+func (mc *ModbusClient) ForceSingleCoilContext(ctx context.Context, addr uint16, value bool) error {
 	v := uint16(0)
 	if value {
 		v = 0xFF00
 	}
 	request := buildRequest(mc.GetTransactionId(), mc.TypeProtocol, mc.DevID, FcForceSingleCoil, addr, v)
-	answer, err := mc.SendRequest(request)
+	answer, err := mc.SendRequestContext(ctx, request)
 	if err != nil {
 		return err
 	}
-	if byte(answer.GetFunctionCode())&0x80 > 0 {
-		return fmt.Errorf("Error code %x", answer.GetErrorCode())
-	}
-	return nil
-
+	return answer.GetException()
 }
 
 // Send Request PresetMultipleRegisters
 func (mc *ModbusClient) PresetMultipleRegisters(addr, cnt uint16, data ...uint16) error {
+	return mc.PresetMultipleRegistersContext(context.Background(), addr, cnt, data...)
+}
+
+// Send Request PresetMultipleRegisters, request is interrupted when ctx is done
+func (mc *ModbusClient) PresetMultipleRegistersContext(ctx context.Context, addr, cnt uint16, data ...uint16) error {
+	if err := checkWriteQuantity(cnt, len(data), MaxWriteRegisters); err != nil {
+		return err
+	}
 	request := buildRequest(mc.GetTransactionId(), mc.TypeProtocol, mc.DevID, FcPresetMultipleRegisters, addr, cnt, wordArrToByteArr(data)...)
-	answer, err := mc.SendRequest(request)
+	answer, err := mc.SendRequestContext(ctx, request)
 	if err != nil {
 		return err
 	}
-	if byte(answer.GetFunctionCode())&0x80 > 0 {
-		return fmt.Errorf("Error code %x", answer.GetErrorCode())
-	}
-	return nil
+	return answer.GetException()
 }
 
 // Send Request ForceMultipleCoils
 func (mc *ModbusClient) ForceMultipleCoils(addr, cnt uint16, data ...bool) error {
+	return mc.ForceMultipleCoilsContext(context.Background(), addr, cnt, data...)
+}
+
+// Send Request ForceMultipleCoils, request is interrupted when ctx is done
+func (mc *ModbusClient) ForceMultipleCoilsContext(ctx context.Context, addr, cnt uint16, data ...bool) error {
+	if err := checkWriteQuantity(cnt, len(data), MaxWriteCoils); err != nil {
+		return err
+	}
 	request := buildRequest(mc.GetTransactionId(), mc.TypeProtocol, mc.DevID, FcForceMultipleCoils, addr, cnt, boolArrToByteArr(data)...)
-	answer, err := mc.SendRequest(request)
+	answer, err := mc.SendRequestContext(ctx, request)
 	if err != nil {
 		return err
 	}
-	if byte(answer.GetFunctionCode())&0x80 > 0 {
-		return fmt.Errorf("Error code %x", answer.GetErrorCode())
+	return answer.GetException()
+}
+
+// Checks quantity of write request, quantity must be equal to count of data
+func checkWriteQuantity(cnt uint16, data_cnt, max int) error {
+	if int(cnt) != data_cnt {
+		return fmt.Errorf("Quantity %d doesn't match count %d of data", cnt, data_cnt)
 	}
-	return nil
+	return checkQuantity(cnt, max)
+}
+
+// Get data of read answer, byte count must match quantity of request
+func answerData(answer *ModbusPacket, byte_cnt int) ([]byte, error) {
+	cnt, data := answer.GetData()
+	if int(cnt) != byte_cnt || len(data) != byte_cnt {
+		return nil, fmt.Errorf("Bad byte count %d in answer, expected %d", cnt, byte_cnt)
+	}
+	return data, nil
 }
 
-// Close client
+// Close client, request in progress is interrupted
 func (mc *ModbusClient) Close() {
+	mc.connMu.Lock()
+	defer mc.connMu.Unlock()
 	// Close the connection when you're done with it.
-	mc.Conn.Close()
+	mc.closed = true
+	if mc.Conn != nil {
+		mc.Conn.Close()
+	}
 }
 
 // Get Transaction ID
 func (mc *ModbusClient) GetTransactionId() uint16 {
+	mc.tidMu.Lock()
+	defer mc.tidMu.Unlock()
 	mc.TranscationId++
 	if mc.TranscationId == math.MaxUint16 {
 		mc.TranscationId = 0
 	}
 	return mc.TranscationId
 }
+
+// Send Request MaskWriteRegister
+func (mc *ModbusClient) MaskWriteRegister(addr, and_mask, or_mask uint16) error {
+	return mc.MaskWriteRegisterContext(context.Background(), addr, and_mask, or_mask)
+}
+
+// Send Request MaskWriteRegister, request is interrupted when ctx is done
+func (mc *ModbusClient) MaskWriteRegisterContext(ctx context.Context, addr, and_mask, or_mask uint16) error {
+	request := mc.buildRawRequest(FcMaskWriteRegister, wordArrToByteArr([]uint16{addr, and_mask, or_mask}))
+	answer, err := mc.SendRequestContext(ctx, request)
+	if err != nil {
+		return err
+	}
+	if err = answer.GetException(); err != nil {
+		return err
+	}
+	// Answer echoes address and masks
+	if !bytes.Equal(answer.GetRawPDU(), request.GetRawPDU()) {
+		return errors.New("Answer doesn't match request")
+	}
+	return nil
+}
+
+// Send Request ReadWriteMultipleRegisters, write is performed before read
+func (mc *ModbusClient) ReadWriteMultipleRegisters(read_addr, read_cnt, write_addr uint16, data ...uint16) ([]uint16, error) {
+	return mc.ReadWriteMultipleRegistersContext(context.Background(), read_addr, read_cnt, write_addr, data...)
+}
+
+// Send Request ReadWriteMultipleRegisters, request is interrupted when ctx
+// is done
+func (mc *ModbusClient) ReadWriteMultipleRegistersContext(ctx context.Context, read_addr, read_cnt, write_addr uint16,
+	data ...uint16) ([]uint16, error) {
+	if err := checkQuantity(read_cnt, MaxReadRegisters); err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || len(data) > MaxReadWriteRegisters {
+		return nil, fmt.Errorf("Quantity %d outside the valid range 1...%d", len(data), MaxReadWriteRegisters)
+	}
+	req := wordArrToByteArr([]uint16{read_addr, read_cnt, write_addr, uint16(len(data))})
+	req = append(req, byte(2*len(data)))
+	request := mc.buildRawRequest(FcReadWriteMultipleRegisters, append(req, wordArrToByteArr(data)...))
+	answer, err := mc.SendRequestContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if err = answer.GetException(); err != nil {
+		return nil, err
+	}
+	res, err := answerData(answer, registersByteCnt(read_cnt))
+	if err != nil {
+		return nil, err
+	}
+	return byteArrToWordArr(res), nil
+}
@@ -0,0 +1,624 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Start server on free local port and connect client to it
+func startTestServer(t *testing.T, mbprotocol ModbusTypeProtocol) (*ModbusServer, *ModbusClient) {
+	md := new(ModbusData)
+	md.Init(10, 10, 10, 10)
+	srv := NewServer("127.0.0.1", "0", mbprotocol, md)
+	if err := srv.Start(); err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	_, port, _ := net.SplitHostPort(srv.ln.Addr().String())
+	cl, err := NewClient(port, "127.0.0.1", mbprotocol, 1)
+	if err != nil {
+		srv.Stop()
+		t.Fatal("Expected nil, got", err)
+	}
+	return srv, cl
+}
+
+func TestModbusClient_Registers(t *testing.T) {
+	for _, mbprotocol := range []ModbusTypeProtocol{ModbusTCP, ModbusRTUviaTCP, ModbusASCII} {
+		srv, cl := startTestServer(t, mbprotocol)
+		test_data := []uint16{0x01, 0x02, 0x03}
+		if err := cl.PresetMultipleRegisters(1, uint16(len(test_data)), test_data...); err != nil {
+			t.Error("For", mbprotocol, "expected nil, got", err)
+		}
+		res, err := cl.ReadHoldingRegisters(1, uint16(len(test_data)))
+		if err != nil {
+			t.Error("For", mbprotocol, "expected nil, got", err)
+		}
+		for i, v := range test_data {
+			if i >= len(res) || res[i] != v {
+				t.Error("For", mbprotocol, "expected", test_data, "got", res)
+				break
+			}
+		}
+		cl.Close()
+		srv.Stop()
+	}
+}
+
+func TestModbusClient_Coils(t *testing.T) {
+	for _, mbprotocol := range []ModbusTypeProtocol{ModbusTCP, ModbusRTUviaTCP, ModbusASCII} {
+		srv, cl := startTestServer(t, mbprotocol)
+		test_data := []bool{true, false, true, true}
+		if err := cl.ForceMultipleCoils(2, uint16(len(test_data)), test_data...); err != nil {
+			t.Error("For", mbprotocol, "expected nil, got", err)
+		}
+		if err := cl.ForceSingleCoil(0, true); err != nil {
+			t.Error("For", mbprotocol, "expected nil, got", err)
+		}
+		res, err := cl.ReadCoilStatus(0, 6)
+		if err != nil {
+			t.Error("For", mbprotocol, "expected nil, got", err)
+		}
+		target := []bool{true, false, true, false, true, true}
+		for i, v := range target {
+			if i >= len(res) || res[i] != v {
+				t.Error("For", mbprotocol, "expected", target, "got", res)
+				break
+			}
+		}
+		cl.Close()
+		srv.Stop()
+	}
+}
+
+func TestModbusClient_Quantity(t *testing.T) {
+	srv, cl := startTestServer(t, ModbusTCP)
+	defer srv.Stop()
+	defer cl.Close()
+	if _, err := cl.ReadHoldingRegisters(0, MaxReadRegisters+1); err == nil {
+		t.Error("Expected error, got nil")
+	}
+	if _, err := cl.ReadCoilStatus(0, 0); err == nil {
+		t.Error("Expected error, got nil")
+	}
+	if err := cl.PresetMultipleRegisters(0, 2, 1); err == nil {
+		t.Error("Expected error, got nil")
+	}
+	if err := cl.ForceMultipleCoils(0, MaxWriteCoils+1, make([]bool, MaxWriteCoils+1)...); err == nil {
+		t.Error("Expected error, got nil")
+	}
+	if _, err := cl.ReadWriteMultipleRegisters(0, 1, 0); err == nil {
+		t.Error("Expected error, got nil")
+	}
+	// Quantity is inside limits, address is outside
+	if _, err := cl.ReadCoilStatus(0, MaxReadCoils); !errors.Is(err, ErrOutside) {
+		t.Error("Expected", ErrOutside, "got", err)
+	}
+}
+
+func TestModbusClient_MaskWriteRegister(t *testing.T) {
+	for _, mbprotocol := range []ModbusTypeProtocol{ModbusTCP, ModbusRTUviaTCP, ModbusASCII} {
+		srv, cl := startTestServer(t, mbprotocol)
+		cl.PresetSingleRegister(4, 0x12)
+		if err := cl.MaskWriteRegister(4, 0xF2, 0x25); err != nil {
+			t.Error("For", mbprotocol, "expected nil, got", err)
+		}
+		res, err := cl.ReadWriteMultipleRegisters(3, 2, 0, 7, 8)
+		if err != nil || len(res) != 2 || res[0] != 0 || res[1] != 0x17 {
+			t.Error("For", mbprotocol, "expected [0 23], got", res, err)
+		}
+		res, err = cl.ReadWriteMultipleRegisters(0, 2, 5, 0)
+		if err != nil || len(res) != 2 || res[0] != 7 || res[1] != 8 {
+			t.Error("For", mbprotocol, "expected [7 8], got", res, err)
+		}
+		if err = cl.MaskWriteRegister(10, 0, 0); !errors.Is(err, ErrOutside) {
+			t.Error("For", mbprotocol, "expected", ErrOutside, "got", err)
+		}
+		cl.Close()
+		srv.Stop()
+	}
+}
+
+func TestModbusClient_BadAnswerData(t *testing.T) {
+	md := new(ModbusData)
+	md.Init(10, 10, 10, 10)
+	srv := NewServer("127.0.0.1", "0", ModbusTCP, md)
+	// Answers which don't fit requests
+	srv.Use(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, request *ModbusPacket) (*ModbusPacket, error) {
+			switch request.GetFunctionCode() {
+			case FcReadWriteMultipleRegisters:
+				return NewAnswer(request, []byte{0x4, 0x0, 0x1, 0x0, 0x2}), nil
+			case FcMaskWriteRegister:
+				return NewAnswer(request, []byte{0x0, 0x5, 0x0, 0xF2, 0x0, 0x25}), nil
+			}
+			return next.ServeModbus(ctx, request)
+		})
+	})
+	if err := srv.Start(); err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer srv.Stop()
+	_, port, _ := net.SplitHostPort(srv.ln.Addr().String())
+	cl, err := NewClient(port, "127.0.0.1", ModbusTCP, 1)
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer cl.Close()
+
+	if res, err := cl.ReadWriteMultipleRegisters(0, 1, 5, 0); err == nil {
+		t.Error("Expected error, got", res)
+	}
+	if err = cl.MaskWriteRegister(4, 0xF2, 0x25); err == nil {
+		t.Error("Expected error, got nil")
+	}
+}
+
+// Start UDP server on free local port and connect client to it
+func startTestUDPServer(t *testing.T, mbprotocol ModbusTypeProtocol) (*ModbusServer, *ModbusClient) {
+	md := new(ModbusData)
+	md.Init(10, 10, 10, 10)
+	srv := NewUDPServer("127.0.0.1", "0", mbprotocol, md)
+	if err := srv.Start(); err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	_, port, _ := net.SplitHostPort(srv.pc.LocalAddr().String())
+	cl, err := NewUDPClient(port, "127.0.0.1", mbprotocol, 1)
+	if err != nil {
+		srv.Stop()
+		t.Fatal("Expected nil, got", err)
+	}
+	return srv, cl
+}
+
+func TestModbusClient_UDP(t *testing.T) {
+	for _, mbprotocol := range []ModbusTypeProtocol{ModbusTCP, ModbusRTUviaTCP, ModbusASCII} {
+		srv, cl := startTestUDPServer(t, mbprotocol)
+		test_data := []uint16{0x01, 0x02, 0x03}
+		if err := cl.PresetMultipleRegisters(1, uint16(len(test_data)), test_data...); err != nil {
+			t.Error("For", mbprotocol, "expected nil, got", err)
+		}
+		res, err := cl.ReadHoldingRegisters(1, uint16(len(test_data)))
+		if err != nil {
+			t.Error("For", mbprotocol, "expected nil, got", err)
+		}
+		for i, v := range test_data {
+			if i >= len(res) || res[i] != v {
+				t.Error("For", mbprotocol, "expected", test_data, "got", res)
+				break
+			}
+		}
+		cl.Close()
+		srv.Stop()
+	}
+}
+
+func TestModbusClient_UDPRetransmit(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer pc.Close()
+
+	md := new(ModbusData)
+	md.Init(10, 10, 10, 10)
+	md.PresetMultipleRegisters(0, 0x01, 0x02)
+	srv := &ModbusServer{}
+	srv.Data = md
+	go func() {
+		buf := make([]byte, ModbusTCPMaxSize)
+		// Lost request
+		pc.ReadFrom(buf)
+		// Answer for retransmitted request with stale answer before
+		n, addr, _ := pc.ReadFrom(buf)
+		request := &ModbusPacket{}
+		request.Init(ModbusTCP)
+		request.Length = copy(request.PDU, buf[:n])
+		answer, _ := srv.RequestHadler(request)
+		stale := buildAnswer(request)
+		stale.SetTransactionId(request.GetTransactionId() - 1)
+		pc.WriteTo(stale.GetFrame(), addr)
+		pc.WriteTo(answer.GetFrame(), addr)
+	}()
+
+	_, port, _ := net.SplitHostPort(pc.LocalAddr().String())
+	cl, err := NewUDPClient(port, "127.0.0.1", ModbusTCP, 1)
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer cl.Close()
+	cl.Timeout = 100 * time.Millisecond
+	res, err := cl.ReadHoldingRegisters(0, 2)
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	if len(res) != 2 || res[0] != 0x01 || res[1] != 0x02 {
+		t.Error("Expected [1 2], got", res)
+	}
+}
+
+// Middleware delaying first request, so it is answered after
+// retransmission or timeout
+func delayFirstRequest(delay time.Duration) Middleware {
+	var delayed int32
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, request *ModbusPacket) (*ModbusPacket, error) {
+			if atomic.CompareAndSwapInt32(&delayed, 0, 1) {
+				time.Sleep(delay)
+			}
+			return next.ServeModbus(ctx, request)
+		})
+	}
+}
+
+func TestModbusClient_UDPLateAnswer(t *testing.T) {
+	md := new(ModbusData)
+	md.Init(10, 10, 10, 10)
+	srv := NewUDPServer("127.0.0.1", "0", ModbusRTUviaTCP, md)
+	srv.Use(delayFirstRequest(150 * time.Millisecond))
+	if err := srv.Start(); err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer srv.Stop()
+	_, port, _ := net.SplitHostPort(srv.pc.LocalAddr().String())
+	cl, err := NewUDPClient(port, "127.0.0.1", ModbusRTUviaTCP, 1)
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer cl.Close()
+	cl.Timeout = 100 * time.Millisecond
+
+	// First answer is received after retransmission, answer to
+	// retransmitted request is late
+	if _, err = cl.ReadHoldingRegisters(0, 1); err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	if res, err := cl.ReadHoldingRegisters(50, 1); !errors.Is(err, ErrOutside) {
+		t.Error("Expected", ErrOutside, "got", res, err)
+	}
+}
+
+type testanswerMatchespair struct {
+	request, answer *ModbusPacket
+	res             bool
+}
+
+func TestAnswerMatches(t *testing.T) {
+	read := buildRequest(0, ModbusRTUviaTCP, 1, FcReadHoldingRegisters, 0, 1)
+	coils := buildRequest(0, ModbusRTUviaTCP, 1, FcReadCoilStatus, 0, 9)
+	write := buildRequest(0, ModbusRTUviaTCP, 1, FcPresetSingleRegister, 0, 5)
+	multiple := buildRequest(0, ModbusRTUviaTCP, 1, FcPresetMultipleRegisters, 0, 1, 0x0, 0x5)
+	tests := []testanswerMatchespair{
+		{read, NewAnswer(read, []byte{0x2, 0x0, 0x1}), true},
+		{read, NewAnswer(read, []byte{0x4, 0x0, 0x1, 0x0, 0x2}), false},
+		{read, buildErrAnswer(read, ErrOutside), true},
+		{coils, NewAnswer(coils, []byte{0x2, 0x1, 0x0}), true},
+		{coils, NewAnswer(coils, []byte{0x1, 0x1}), false},
+		{write, NewAnswer(write, []byte{0x0, 0x0, 0x0, 0x5}), true},
+		{write, NewAnswer(write, []byte{0x0, 0x1, 0x0, 0x5}), false},
+		{multiple, NewAnswer(multiple, []byte{0x0, 0x0, 0x0, 0x1}), true},
+		{multiple, NewAnswer(multiple, []byte{0x0, 0x0, 0x0, 0x2}), false},
+		// Other function is reported by checkAnswer
+		{read, NewAnswer(write, []byte{0x0, 0x0, 0x0, 0x5}), true},
+	}
+	for i, pair := range tests {
+		if res := answerMatches(pair.request, pair.answer); res != pair.res {
+			t.Error("For", i, "expected", pair.res, "got", res)
+		}
+	}
+}
+
+type testStreamLateAnswerpair struct {
+	delay     time.Duration
+	reconnect *ReconnectPolicy
+}
+
+func TestModbusClient_StreamLateAnswer(t *testing.T) {
+	tests := []testStreamLateAnswerpair{
+		// Late answer is flushed
+		{120 * time.Millisecond, nil},
+		// Connection is redialed
+		{300 * time.Millisecond, &ReconnectPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2}},
+	}
+	for _, pair := range tests {
+		md := new(ModbusData)
+		md.Init(10, 10, 10, 10)
+		srv := NewServer("127.0.0.1", "0", ModbusRTUviaTCP, md)
+		srv.Use(delayFirstRequest(pair.delay))
+		if err := srv.Start(); err != nil {
+			t.Fatal("Expected nil, got", err)
+		}
+		_, port, _ := net.SplitHostPort(srv.ln.Addr().String())
+		cl, err := NewClient(port, "127.0.0.1", ModbusRTUviaTCP, 1)
+		if err != nil {
+			srv.Stop()
+			t.Fatal("Expected nil, got", err)
+		}
+		cl.Timeout = 100 * time.Millisecond
+		cl.Reconnect = pair.reconnect
+
+		if _, err = cl.ReadHoldingRegisters(0, 1); !isTimeout(err) {
+			t.Error("For", pair.delay, "expected timeout, got", err)
+		}
+		// Late answer to first request must not be taken as answer
+		if res, err := cl.ReadHoldingRegisters(50, 1); !errors.Is(err, ErrOutside) {
+			t.Error("For", pair.delay, "expected", ErrOutside, "got", res, err)
+		}
+		cl.Close()
+		srv.Stop()
+	}
+}
+
+func TestModbusClient_TCPPartialAnswer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer ln.Close()
+
+	md := new(ModbusData)
+	md.Init(10, 10, 10, 10)
+	md.PresetMultipleRegisters(0, 0x01, 0x02)
+	srv := &ModbusServer{}
+	srv.Data = md
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fr := newFrameReader(conn, ModbusTCP, false)
+		for i := 0; i < 2; i++ {
+			request := &ModbusPacket{}
+			request.Init(ModbusTCP)
+			if fr.ReadFrame(request) != nil {
+				return
+			}
+			answer, _ := srv.RequestHadler(request)
+			frame := answer.GetFrame()
+			if i == 0 {
+				// Rest of first answer comes after timeout
+				conn.Write(frame[:4])
+				time.Sleep(150 * time.Millisecond)
+				conn.Write(frame[4:])
+				continue
+			}
+			conn.Write(frame)
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	cl, err := NewClient(port, "127.0.0.1", ModbusTCP, 1)
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer cl.Close()
+	cl.Timeout = 100 * time.Millisecond
+
+	if _, err = cl.ReadHoldingRegisters(0, 1); !isTimeout(err) {
+		t.Error("Expected timeout, got", err)
+	}
+	res, err := cl.ReadHoldingRegisters(0, 2)
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	if len(res) != 2 || res[0] != 0x01 || res[1] != 0x02 {
+		t.Error("Expected [1 2], got", res)
+	}
+}
+
+func TestModbusClient_Exception(t *testing.T) {
+	for _, mbprotocol := range []ModbusTypeProtocol{ModbusTCP, ModbusRTUviaTCP, ModbusASCII} {
+		srv, cl := startTestServer(t, mbprotocol)
+		var exc *ExceptionError
+		_, err := cl.ReadHoldingRegisters(8, 5)
+		if !errors.As(err, &exc) || exc.ExceptionCode != ErrOutside || exc.FunctionCode != FcReadHoldingRegisters {
+			t.Error("For", mbprotocol, "expected exception", ErrOutside, "got", err)
+		}
+		err = cl.PresetSingleRegister(10, 1)
+		if !errors.As(err, &exc) || exc.ExceptionCode != ErrOutside || exc.FunctionCode != FcPresetSingleRegister {
+			t.Error("For", mbprotocol, "expected exception", ErrOutside, "got", err)
+		}
+		_, err = cl.ReadCoilStatus(9, 2)
+		if !errors.Is(err, ErrOutside) {
+			t.Error("For", mbprotocol, "expected exception", ErrOutside, "got", err)
+		}
+		cl.Close()
+		srv.Stop()
+	}
+}
+
+func TestModbusClient_Concurrent(t *testing.T) {
+	for _, mbprotocol := range []ModbusTypeProtocol{ModbusTCP, ModbusRTUviaTCP} {
+		srv, cl := startTestServer(t, mbprotocol)
+		var wg sync.WaitGroup
+		for i := uint16(0); i < 10; i++ {
+			wg.Add(1)
+			go func(addr uint16) {
+				defer wg.Done()
+				for j := uint16(0); j < 10; j++ {
+					if err := cl.PresetSingleRegister(addr, addr+j); err != nil {
+						t.Error("For", mbprotocol, "expected nil, got", err)
+						return
+					}
+					res, err := cl.ReadHoldingRegisters(addr, 1)
+					if err != nil || len(res) != 1 || res[0] != addr+j {
+						t.Error("For", mbprotocol, "expected", addr+j, "got", res, err)
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		cl.Close()
+		srv.Stop()
+	}
+}
+
+func TestModbusClient_StaleAnswer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		request := &ModbusPacket{}
+		request.Init(ModbusTCP)
+		if newFrameReader(conn, ModbusTCP, false).ReadFrame(request) != nil {
+			return
+		}
+		// Late answer to previous request goes first
+		stale := buildAnswer(request, 0, 1)
+		stale.SetTransactionId(request.GetTransactionId() - 1)
+		conn.Write(stale.GetFrame())
+		conn.Write(buildAnswer(request, 0, 2).GetFrame())
+		conn.Read(make([]byte, 1))
+	}()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	cl, err := NewClient(port, "127.0.0.1", ModbusTCP, 1)
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer cl.Close()
+	res, err := cl.ReadHoldingRegisters(0, 1)
+	if err != nil || len(res) != 1 || res[0] != 2 {
+		t.Error("Expected [2], got", res, err)
+	}
+}
+
+type testBadAnswerpair struct {
+	corrupt func(answer *ModbusPacket)
+	err     error
+}
+
+var testsBadAnswer = []testBadAnswerpair{
+	{func(answer *ModbusPacket) { answer.aPDU[answer.Length-1] ^= 0xFF }, ErrBadChecksum},
+	{func(answer *ModbusPacket) { answer.SetDevID(2); answer.Length -= 2; answer.SetCrc() }, ErrUnitIdMismatch},
+	{func(answer *ModbusPacket) {
+		answer.SetFunctionCode(FcReadInputRegisters)
+		answer.Length -= 2
+		answer.SetCrc()
+	}, ErrFunctionCodeMismatch},
+}
+
+func TestModbusClient_BadAnswer(t *testing.T) {
+	for _, pair := range testsBadAnswer {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal("Expected nil, got", err)
+		}
+		go func(corrupt func(answer *ModbusPacket)) {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			request := &ModbusPacket{}
+			request.Init(ModbusRTUviaTCP)
+			if newFrameReader(conn, ModbusRTUviaTCP, false).ReadFrame(request) != nil {
+				return
+			}
+			answer := buildAnswer(request, 0, 1)
+			corrupt(answer)
+			conn.Write(answer.GetFrame())
+			conn.Read(make([]byte, 1))
+		}(pair.corrupt)
+
+		_, port, _ := net.SplitHostPort(ln.Addr().String())
+		cl, err := NewClient(port, "127.0.0.1", ModbusRTUviaTCP, 1)
+		if err != nil {
+			ln.Close()
+			t.Fatal("Expected nil, got", err)
+		}
+		if _, err = cl.ReadHoldingRegisters(0, 1); !errors.Is(err, pair.err) {
+			t.Error("Expected", pair.err, "got", err)
+		}
+		cl.Close()
+		ln.Close()
+	}
+}
+
+// Start listener which accepts connections and never answers
+func startSilentServer(t *testing.T) (net.Listener, *ModbusClient) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	cl, err := NewClient(port, "127.0.0.1", ModbusTCP, 1)
+	if err != nil {
+		ln.Close()
+		t.Fatal("Expected nil, got", err)
+	}
+	return ln, cl
+}
+
+func TestModbusClient_Timeout(t *testing.T) {
+	ln, cl := startSilentServer(t)
+	defer ln.Close()
+	defer cl.Close()
+
+	cl.Timeout = 50 * time.Millisecond
+	start := time.Now()
+	_, err := cl.ReadHoldingRegisters(0, 1)
+	if !isTimeout(err) {
+		t.Error("Expected timeout, got", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("Expected answer timeout", cl.Timeout, "got", time.Since(start))
+	}
+}
+
+func TestModbusClient_Context(t *testing.T) {
+	ln, cl := startSilentServer(t)
+	defer ln.Close()
+	defer cl.Close()
+
+	// Wait answer forever, only ctx limits request
+	cl.Timeout = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := cl.ReadHoldingRegistersContext(ctx, 0, 1)
+	if err != context.DeadlineExceeded {
+		t.Error("Expected", context.DeadlineExceeded, "got", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	err = cl.PresetSingleRegisterContext(ctx, 0, 1)
+	if err != context.Canceled {
+		t.Error("Expected", context.Canceled, "got", err)
+	}
+
+	// Canceled context does not send request
+	err = cl.ForceSingleCoilContext(ctx, 0, true)
+	if err != context.Canceled {
+		t.Error("Expected", context.Canceled, "got", err)
+	}
+}
@@ -9,17 +9,76 @@ import (
 	"sync"
 )
 
-// ModbusData implements data interface
+// ModbusDataStore is storage of four Modbus tables: coils, discrete inputs,
+// holding registers and input registers. ModbusServer, ModbusRest and
+// ModbusService serve any data store, ModbusData keeps tables in memory.
+// ModbusErrors returned by store, like ErrOutside, are answered as
+// exceptions, other errors are answered as ErrDeviceFailure.
+type ModbusDataStore interface {
+	ReadCoilStatus(addr, cnt uint16) ([]bool, error)
+	ForceMultipleCoils(addr uint16, data ...bool) error
+	ReadDescreteInputs(addr, cnt uint16) ([]bool, error)
+	ReadHoldingRegisters(addr, cnt uint16) ([]uint16, error)
+	PresetMultipleRegisters(addr uint16, data ...uint16) error
+	ReadInputRegisters(addr, cnt uint16) ([]uint16, error)
+}
+
+// ModbusMaskWriter is implemented by data store which changes register by
+// mask atomically, otherwise register is read and written by two calls
+type ModbusMaskWriter interface {
+	MaskWriteRegister(addr, and_mask, or_mask uint16) error
+}
+
+// ModbusReadWriter is implemented by data store which writes and reads
+// registers in one step, otherwise registers are written and read by two
+// calls
+type ModbusReadWriter interface {
+	ReadWriteMultipleRegisters(read_addr, read_cnt, write_addr uint16, data ...uint16) ([]uint16, error)
+}
+
+var _ ModbusDataStore = (*ModbusData)(nil)
+
+// Mask Write Register in data store
+func maskWriteRegister(ds ModbusDataStore, addr, and_mask, or_mask uint16) error {
+	if mw, ok := ds.(ModbusMaskWriter); ok {
+		return mw.MaskWriteRegister(addr, and_mask, or_mask)
+	}
+	res, err := ds.ReadHoldingRegisters(addr, 1)
+	if err != nil {
+		return err
+	}
+	if len(res) != 1 {
+		return fmt.Errorf("Data store returned %d registers instead of 1", len(res))
+	}
+	return ds.PresetMultipleRegisters(addr, res[0]&and_mask|or_mask&^and_mask)
+}
+
+// Read/Write Multiple Registers in data store, write is performed before
+// read
+func readWriteMultipleRegisters(ds ModbusDataStore, read_addr, read_cnt, write_addr uint16, data ...uint16) ([]uint16, error) {
+	if rw, ok := ds.(ModbusReadWriter); ok {
+		return rw.ReadWriteMultipleRegisters(read_addr, read_cnt, write_addr, data...)
+	}
+	if err := ds.PresetMultipleRegisters(write_addr, data...); err != nil {
+		return nil, err
+	}
+	return ds.ReadHoldingRegisters(read_addr, read_cnt)
+}
+
+// ModbusData implements ModbusDataStore in memory
 type ModbusData struct {
 	coils, discrete_inputs    []bool
 	holding_reg, input_reg    []uint16
 	mu_holding_regs, mu_coils *sync.Mutex
+	fifos                     map[uint16]*ModbusFIFO
+	mu_fifos                  sync.Mutex
 }
 
 // Checks that requested data is not outside the present range
 func (md *ModbusData) isNotOutside(addr, cnt uint16, datasize int) (bool, error) {
-	if addr+cnt > uint16(datasize) {
-		err := fmt.Errorf("Requested data %d...%d outside the valid range 0...%d", addr, addr+cnt, datasize)
+	// Sum is calculated in int, so it doesn't wrap
+	if int(addr)+int(cnt) > datasize {
+		err := fmt.Errorf("%w: data %d...%d, valid range 0...%d", ErrOutside, addr, int(addr)+int(cnt), datasize)
 		return false, err
 	}
 
@@ -50,7 +109,7 @@ func (md *ModbusData) PresetMultipleRegisters(addr uint16, data ...uint16) error
 	if err == nil {
 		md.mu_holding_regs.Lock()
 		defer md.mu_holding_regs.Unlock()
-		copy(md.holding_reg[addr:addr+cnt], data)
+		copy(md.holding_reg[addr:int(addr)+int(cnt)], data)
 	}
 	return err
 }
@@ -60,7 +119,7 @@ func (md *ModbusData) PresetMultipleInputsRegisters(addr uint16, data ...uint16)
 	cnt := uint16(len(data))
 	_, err := md.isNotOutside(addr, cnt, len(md.input_reg))
 	if err == nil {
-		copy(md.input_reg[addr:addr+cnt], data)
+		copy(md.input_reg[addr:int(addr)+int(cnt)], data)
 	}
 	return err
 }
@@ -71,7 +130,36 @@ func (md *ModbusData) ReadHoldingRegisters(addr, cnt uint16) ([]uint16, error) {
 	if err != nil {
 		return nil, err
 	}
-	return md.holding_reg[addr : addr+cnt], err
+	return md.holding_reg[addr : int(addr)+int(cnt)], err
+}
+
+// Mask Write Register, result is (current AND and_mask) OR (or_mask AND
+// NOT and_mask). Register is changed atomically.
+func (md *ModbusData) MaskWriteRegister(addr, and_mask, or_mask uint16) error {
+	_, err := md.isNotOutside(addr, 1, len(md.holding_reg))
+	if err == nil {
+		md.mu_holding_regs.Lock()
+		defer md.mu_holding_regs.Unlock()
+		md.holding_reg[addr] = md.holding_reg[addr]&and_mask | or_mask&^and_mask
+	}
+	return err
+}
+
+// Read/Write Multiple Registers, write is performed before read in one
+// locked step
+func (md *ModbusData) ReadWriteMultipleRegisters(read_addr, read_cnt, write_addr uint16, data ...uint16) ([]uint16, error) {
+	if _, err := md.isNotOutside(read_addr, read_cnt, len(md.holding_reg)); err != nil {
+		return nil, err
+	}
+	if _, err := md.isNotOutside(write_addr, uint16(len(data)), len(md.holding_reg)); err != nil {
+		return nil, err
+	}
+	md.mu_holding_regs.Lock()
+	defer md.mu_holding_regs.Unlock()
+	copy(md.holding_reg[write_addr:], data)
+	res := make([]uint16, read_cnt)
+	copy(res, md.holding_reg[read_addr:int(read_addr)+int(read_cnt)])
+	return res, nil
 }
 
 // Read Input Registers
@@ -80,7 +168,7 @@ func (md *ModbusData) ReadInputRegisters(addr, cnt uint16) ([]uint16, error) {
 	if err != nil {
 		return nil, err
 	}
-	return md.input_reg[addr : addr+cnt], err
+	return md.input_reg[addr : int(addr)+int(cnt)], err
 }
 
 // Read Coil Status
@@ -89,7 +177,7 @@ func (md *ModbusData) ReadCoilStatus(addr, cnt uint16) ([]bool, error) {
 	if err != nil {
 		return nil, err
 	}
-	return md.coils[addr : addr+cnt], nil
+	return md.coils[addr : int(addr)+int(cnt)], nil
 }
 
 // Force Single Coil
@@ -104,7 +192,7 @@ func (md *ModbusData) ForceMultipleCoils(addr uint16, data ...bool) error {
 	if err == nil {
 		md.mu_coils.Lock()
 		defer md.mu_coils.Unlock()
-		copy(md.coils[addr:addr+cnt], data)
+		copy(md.coils[addr:int(addr)+int(cnt)], data)
 	}
 	return err
 }
@@ -114,7 +202,7 @@ func (md *ModbusData) ForceMultipleDescreteInputs(addr uint16, data ...bool) err
 	cnt := uint16(len(data))
 	_, err := md.isNotOutside(addr, cnt, len(md.discrete_inputs))
 	if err == nil {
-		copy(md.discrete_inputs[addr:addr+cnt], data)
+		copy(md.discrete_inputs[addr:int(addr)+int(cnt)], data)
 	}
 	return err
 }
@@ -125,5 +213,5 @@ func (md *ModbusData) ReadDescreteInputs(addr, cnt uint16) ([]bool, error) {
 	if err != nil {
 		return nil, err
 	}
-	return md.discrete_inputs[addr : addr+cnt], nil
+	return md.discrete_inputs[addr : int(addr)+int(cnt)], nil
 }
@@ -5,6 +5,8 @@
 package modbus
 
 import (
+	"errors"
+	"net"
 	"sync"
 	"testing"
 )
@@ -19,6 +21,8 @@ var testscheckOutside = []testcheckOutsidepair{
 	{0, 2, 100, true},
 	{0, 3, 200, true},
 	{0, 4, 3, false},
+	{0xFFFF, 2, 10, false},
+	{0xFFFF, 1, 0x10000, true},
 }
 
 func TestModbusData_checkOutside(t *testing.T) {
@@ -39,6 +43,20 @@ func TestModbusData_checkOutside(t *testing.T) {
 	}
 }
 
+func TestModbusData_ErrOutside(t *testing.T) {
+	md := new(ModbusData)
+	md.Init(10, 10, 10, 10)
+	if _, err := md.ReadHoldingRegisters(9, 2); !errors.Is(err, ErrOutside) {
+		t.Error("Expected", ErrOutside, "got", err)
+	}
+	if err := md.ForceMultipleCoils(10, true); !errors.Is(err, ErrOutside) {
+		t.Error("Expected", ErrOutside, "got", err)
+	}
+	if _, err := md.ReadFIFOQueue(0); !errors.Is(err, ErrOutside) {
+		t.Error("Expected", ErrOutside, "got", err)
+	}
+}
+
 func TestModbusData_PresetMultipleRegisters(t *testing.T) {
 	test_addr := uint16(5)
 	test_data := []uint16{10, 20, 30}
@@ -90,3 +108,148 @@ func TestModbusData_ReadCoilStatus(t *testing.T) {
 		}
 	}
 }
+
+func TestModbusData_MaskWriteRegister(t *testing.T) {
+	md := new(ModbusData)
+	md.Init(0, 0, 10, 0)
+	md.PresetSingleRegister(4, 0x12)
+	// Example from Modbus Application Protocol Specification
+	if err := md.MaskWriteRegister(4, 0xF2, 0x25); err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	if md.holding_reg[4] != 0x17 {
+		t.Error("Expected", 0x17, "got", md.holding_reg[4])
+	}
+	if err := md.MaskWriteRegister(10, 0xF2, 0x25); err == nil {
+		t.Error("Expected error, got nil")
+	}
+}
+
+func TestModbusData_ReadWriteMultipleRegisters(t *testing.T) {
+	md := new(ModbusData)
+	md.Init(0, 0, 10, 0)
+	md.PresetMultipleRegisters(0, 1, 2, 3)
+	res, err := md.ReadWriteMultipleRegisters(0, 3, 1, 20, 30)
+	target := []uint16{1, 20, 30}
+	if err != nil || len(res) != len(target) {
+		t.Fatal("Expected", target, "got", res, err)
+	}
+	for i, v := range target {
+		if res[i] != v {
+			t.Error("Expected", target, "got", res)
+			break
+		}
+	}
+	if _, err = md.ReadWriteMultipleRegisters(8, 3, 0, 1); err == nil {
+		t.Error("Expected error, got nil")
+	}
+	if _, err = md.ReadWriteMultipleRegisters(0, 1, 9, 1, 2); err == nil {
+		t.Error("Expected error, got nil")
+	}
+}
+
+// Data store without optional interfaces, registers and coils are kept in
+// maps
+type testDataStore struct {
+	mu    sync.Mutex
+	regs  map[uint16]uint16
+	coils map[uint16]bool
+}
+
+func (ds *testDataStore) ReadCoilStatus(addr, cnt uint16) ([]bool, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	res := make([]bool, cnt)
+	for i := range res {
+		res[i] = ds.coils[addr+uint16(i)]
+	}
+	return res, nil
+}
+
+func (ds *testDataStore) ForceMultipleCoils(addr uint16, data ...bool) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	for i, v := range data {
+		ds.coils[addr+uint16(i)] = v
+	}
+	return nil
+}
+
+// Returns more values than requested
+func (ds *testDataStore) ReadDescreteInputs(addr, cnt uint16) ([]bool, error) {
+	return make([]bool, cnt+1), nil
+}
+
+func (ds *testDataStore) ReadHoldingRegisters(addr, cnt uint16) ([]uint16, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	res := make([]uint16, cnt)
+	for i := range res {
+		res[i] = ds.regs[addr+uint16(i)]
+	}
+	return res, nil
+}
+
+func (ds *testDataStore) PresetMultipleRegisters(addr uint16, data ...uint16) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	for i, v := range data {
+		ds.regs[addr+uint16(i)] = v
+	}
+	return nil
+}
+
+// Returns exception code at address 0 and I/O error at other addresses
+func (ds *testDataStore) ReadInputRegisters(addr, cnt uint16) ([]uint16, error) {
+	if addr == 0 {
+		return nil, ErrBusy
+	}
+	return nil, errors.New("Connection lost")
+}
+
+func TestModbusServer_DataStore(t *testing.T) {
+	ds := &testDataStore{regs: make(map[uint16]uint16), coils: make(map[uint16]bool)}
+	srv := NewServer("127.0.0.1", "0", ModbusTCP, ds)
+	if err := srv.Start(); err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer srv.Stop()
+	_, port, _ := net.SplitHostPort(srv.ln.Addr().String())
+	cl, err := NewClient(port, "127.0.0.1", ModbusTCP, 1)
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer cl.Close()
+
+	if err = cl.PresetSingleRegister(0x100, 0x12); err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	// Mask Write Register is read and write of data store
+	if err = cl.MaskWriteRegister(0x100, 0xF2, 0x25); err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	// Read/Write Multiple Registers is write and read of data store
+	res, err := cl.ReadWriteMultipleRegisters(0x100, 2, 0x101, 0x7)
+	if err != nil || len(res) != 2 || res[0] != 0x17 || res[1] != 0x7 {
+		t.Error("Expected [23 7], got", res, err)
+	}
+	if err = cl.ForceSingleCoil(0x200, true); err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	if coils, err := cl.ReadCoilStatus(0x1FF, 2); err != nil || len(coils) != 2 || coils[0] || !coils[1] {
+		t.Error("Expected [false true], got", coils, err)
+	}
+	if _, err = cl.ReadInputRegisters(0, 1); !errors.Is(err, ErrBusy) {
+		t.Error("Expected", ErrBusy, "got", err)
+	}
+	if _, err = cl.ReadInputRegisters(1, 1); !errors.Is(err, ErrDeviceFailure) {
+		t.Error("Expected", ErrDeviceFailure, "got", err)
+	}
+	if _, err = cl.ReadDescreteInputs(0, 2); !errors.Is(err, ErrDeviceFailure) {
+		t.Error("Expected", ErrDeviceFailure, "got", err)
+	}
+	// Data store has no FIFO queues
+	if _, err = cl.ReadFIFOQueue(0); !errors.Is(err, ErrCantHandel) {
+		t.Error("Expected", ErrCantHandel, "got", err)
+	}
+}
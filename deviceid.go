@@ -0,0 +1,184 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// MEI type of Read Device Identification
+const MEIReadDeviceIdentification byte = 0x0E
+
+// Objects of device identification
+const (
+	DevIdVendorName          byte = 0x00
+	DevIdProductCode         byte = 0x01
+	DevIdMajorMinorRevision  byte = 0x02
+	DevIdVendorUrl           byte = 0x03
+	DevIdProductName         byte = 0x04
+	DevIdModelName           byte = 0x05
+	DevIdUserApplicationName byte = 0x06
+)
+
+// Access type of Read Device Identification
+type ModbusReadDevIdCode byte
+
+const (
+	ReadDevIdBasic      ModbusReadDevIdCode = 0x01 // Stream access to basic objects
+	ReadDevIdRegular    ModbusReadDevIdCode = 0x02 // Stream access to regular objects
+	ReadDevIdExtended   ModbusReadDevIdCode = 0x03 // Stream access to extended objects
+	ReadDevIdIndividual ModbusReadDevIdCode = 0x04 // Access to one object
+)
+
+// Size of answer header: function code, MEI type, access type,
+// conformity level, more follows, next object id and number of objects
+const devIdHeaderSize = 7
+
+// Last object id of stream access
+func (code ModbusReadDevIdCode) lastObject() byte {
+	switch code {
+	case ReadDevIdBasic:
+		return DevIdMajorMinorRevision
+	case ReadDevIdRegular:
+		return 0x7F
+	default:
+		return 0xFF
+	}
+}
+
+// Length of Encapsulated Interface Transport PDU, 0 if more bytes are
+// needed for prediction
+func meiLength(pdu []byte, isAnswer bool) (int, error) {
+	if len(pdu) < 2 {
+		return 0, nil
+	}
+	if pdu[1] != MEIReadDeviceIdentification {
+		return 0, fmt.Errorf("Can't predict length of MEI type 0x%x", pdu[1])
+	}
+	if !isAnswer {
+		return 4, nil
+	}
+	if len(pdu) < devIdHeaderSize {
+		return 0, nil
+	}
+	// List of objects: id, length and value
+	pos := devIdHeaderSize
+	for i := 0; i < int(pdu[6]); i++ {
+		if len(pdu) < pos+2 {
+			return 0, nil
+		}
+		pos += 2 + int(pdu[pos+1])
+	}
+	return pos, nil
+}
+
+// Conformity level of server: highest category of objects with individual
+// access
+func (srv *ModbusServer) conformityLevel() byte {
+	level := byte(ReadDevIdBasic)
+	for id := range srv.Identification {
+		switch {
+		case id > ReadDevIdRegular.lastObject():
+			level = byte(ReadDevIdExtended)
+		case id > ReadDevIdBasic.lastObject() && level < byte(ReadDevIdRegular):
+			level = byte(ReadDevIdRegular)
+		}
+	}
+	return 0x80 | level
+}
+
+// Encapsulated Interface Transport
+func (srv *ModbusServer) EncapsulatedInterface(mp *ModbusPacket) (*ModbusPacket, error) {
+	pdu := mp.GetRawPDU()
+	if len(pdu) < 2 || pdu[1] != MEIReadDeviceIdentification || srv.Identification == nil {
+		return buildErrAnswer(mp, ErrCantHandel), errors.New("Unsupported MEI type")
+	}
+	return srv.ReadDeviceIdentification(mp)
+}
+
+// Read Device Identification
+func (srv *ModbusServer) ReadDeviceIdentification(mp *ModbusPacket) (*ModbusPacket, error) {
+	pdu := mp.GetRawPDU()
+	if len(pdu) != 4 {
+		return buildErrAnswer(mp, ErrBadVal), errors.New("Bad length of request")
+	}
+	code, object := ModbusReadDevIdCode(pdu[2]), pdu[3]
+	if code < ReadDevIdBasic || code > ReadDevIdIndividual {
+		return buildErrAnswer(mp, ErrBadVal), fmt.Errorf("Bad access type %d", code)
+	}
+
+	var ids []byte
+	if code == ReadDevIdIndividual {
+		if _, ok := srv.Identification[object]; !ok {
+			return buildErrAnswer(mp, ErrOutside), fmt.Errorf("Unknown object 0x%x", object)
+		}
+		ids = append(ids, object)
+	} else {
+		// Stream restarts from first object for unknown object
+		if _, ok := srv.Identification[object]; !ok || object > code.lastObject() {
+			object = 0
+		}
+		for id := range srv.Identification {
+			if id >= object && id <= code.lastObject() {
+				ids = append(ids, id)
+			}
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+
+	data := []byte{MEIReadDeviceIdentification, byte(code), srv.conformityLevel(), 0, 0, 0}
+	for _, id := range ids {
+		value := srv.Identification[id]
+		if len(value) > MaxPDUDataSize-len(data)-2 {
+			if data[5] == 0 {
+				// Object doesn't fit into answer even alone
+				value = value[:MaxPDUDataSize-len(data)-2]
+			} else {
+				// More follows
+				data[3], data[4] = 0xFF, id
+				break
+			}
+		}
+		data = append(data, id, byte(len(value)))
+		data = append(data, value...)
+		data[5]++
+	}
+	return NewAnswer(mp, data), nil
+}
+
+// Send Request ReadDeviceIdentification, objects from object id are read
+// with continuation of stream access
+func (mc *ModbusClient) ReadDeviceIdentification(code ModbusReadDevIdCode, object byte) (map[byte]string, error) {
+	return mc.ReadDeviceIdentificationContext(context.Background(), code, object)
+}
+
+// Send Request ReadDeviceIdentification, request is interrupted when ctx is
+// done
+func (mc *ModbusClient) ReadDeviceIdentificationContext(ctx context.Context, code ModbusReadDevIdCode, object byte) (map[byte]string, error) {
+	objects := make(map[byte]string)
+	// Object ids are increasing, so number of requests is limited
+	for i := 0; i < 256; i++ {
+		pdu, err := mc.SendRawPDUContext(ctx, FcEncapsulatedInterface, []byte{MEIReadDeviceIdentification, byte(code), object})
+		if err != nil {
+			return nil, err
+		}
+		if n, err := meiLength(pdu, true); err != nil || n == 0 || n != len(pdu) {
+			return nil, errors.New("Bad answer of ReadDeviceIdentification")
+		}
+		pos := devIdHeaderSize
+		for j := 0; j < int(pdu[6]); j++ {
+			objects[pdu[pos]] = string(pdu[pos+2 : pos+2+int(pdu[pos+1])])
+			pos += 2 + int(pdu[pos+1])
+		}
+		if pdu[4] != 0xFF {
+			return objects, nil
+		}
+		object = pdu[5]
+	}
+	return nil, errors.New("Too many continuations of ReadDeviceIdentification")
+}
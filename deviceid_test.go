@@ -0,0 +1,105 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+var testIdentification = map[byte]string{
+	DevIdVendorName:         "Vendor",
+	DevIdProductCode:        "PC-1",
+	DevIdMajorMinorRevision: "1.2",
+	DevIdProductName:        "Product",
+	0x80:                    strings.Repeat("a", 200),
+	0x81:                    strings.Repeat("b", 200),
+}
+
+type testReadDeviceIdentificationpair struct {
+	code    ModbusReadDevIdCode
+	object  byte
+	objects []byte
+	more    bool
+	err     ModbusErrors
+}
+
+var testsReadDeviceIdentification = []testReadDeviceIdentificationpair{
+	{ReadDevIdBasic, 0, []byte{0, 1, 2}, false, 0},
+	{ReadDevIdBasic, 1, []byte{1, 2}, false, 0},
+	{ReadDevIdBasic, 4, []byte{0, 1, 2}, false, 0},
+	{ReadDevIdRegular, 3, []byte{0, 1, 2, 4}, false, 0},
+	{ReadDevIdExtended, 4, []byte{4, 0x80}, true, 0},
+	{ReadDevIdExtended, 0x81, []byte{0x81}, false, 0},
+	{ReadDevIdIndividual, 4, []byte{4}, false, 0},
+	{ReadDevIdIndividual, 3, nil, false, ErrOutside},
+	{5, 0, nil, false, ErrBadVal},
+}
+
+func TestModbusServer_ReadDeviceIdentification(t *testing.T) {
+	srv := &ModbusServer{Identification: testIdentification}
+	for _, pair := range testsReadDeviceIdentification {
+		req := &ModbusPacket{}
+		req.Init(ModbusTCP)
+		req.buildRawPDU(1, FcEncapsulatedInterface, []byte{MEIReadDeviceIdentification, byte(pair.code), pair.object})
+		answ, _ := srv.ReadDeviceIdentification(req)
+		if pair.err != 0 {
+			if answ.GetErrorCode() != pair.err {
+				t.Error("For", pair, "expected exception", pair.err, "got", answ.GetException())
+			}
+			continue
+		}
+		pdu := answ.GetRawPDU()
+		if n, err := meiLength(pdu, true); err != nil || n != len(pdu) {
+			t.Error("For", pair, "expected length", len(pdu), "got", n, err)
+			continue
+		}
+		if pdu[3] != 0x83 || (pdu[4] == 0xFF) != pair.more || int(pdu[6]) != len(pair.objects) {
+			t.Error("For", pair, "got header", pdu[:devIdHeaderSize])
+			continue
+		}
+		pos := devIdHeaderSize
+		for _, id := range pair.objects {
+			if pdu[pos] != id || string(pdu[pos+2:pos+2+int(pdu[pos+1])]) != testIdentification[id] {
+				t.Error("For", pair, "expected object", id, "got", pdu[pos])
+			}
+			pos += 2 + int(pdu[pos+1])
+		}
+	}
+}
+
+func TestModbusClient_ReadDeviceIdentification(t *testing.T) {
+	for _, mbprotocol := range []ModbusTypeProtocol{ModbusTCP, ModbusRTUviaTCP, ModbusASCII} {
+		srv := NewServer("127.0.0.1", "0", mbprotocol, new(ModbusData))
+		srv.Identification = testIdentification
+		if err := srv.Start(); err != nil {
+			t.Fatal("Expected nil, got", err)
+		}
+		_, port, _ := net.SplitHostPort(srv.ln.Addr().String())
+		cl, err := NewClient(port, "127.0.0.1", mbprotocol, 1)
+		if err != nil {
+			srv.Stop()
+			t.Fatal("Expected nil, got", err)
+		}
+		// Extended objects don't fit into one answer
+		objects, err := cl.ReadDeviceIdentification(ReadDevIdExtended, 0)
+		if err != nil || len(objects) != len(testIdentification) {
+			t.Error("For", mbprotocol, "expected", len(testIdentification), "objects, got", len(objects), err)
+		}
+		for id, v := range testIdentification {
+			if objects[id] != v {
+				t.Error("For", mbprotocol, "expected", v, "got", objects[id])
+			}
+		}
+		_, err = cl.ReadDeviceIdentification(ReadDevIdIndividual, DevIdModelName)
+		if !errors.Is(err, ErrOutside) {
+			t.Error("For", mbprotocol, "expected", ErrOutside, "got", err)
+		}
+		cl.Close()
+		srv.Stop()
+	}
+}
@@ -0,0 +1,470 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Sub-function of Diagnostics
+type ModbusDiagSubFunction uint16
+
+const (
+	DiagReturnQueryData          ModbusDiagSubFunction = 0x00
+	DiagRestartCommunications    ModbusDiagSubFunction = 0x01
+	DiagReturnDiagnosticRegister ModbusDiagSubFunction = 0x02
+	DiagForceListenOnlyMode      ModbusDiagSubFunction = 0x04
+	DiagClearCounters            ModbusDiagSubFunction = 0x0A
+	DiagBusMessageCount          ModbusDiagSubFunction = 0x0B
+	DiagBusCommErrorCount        ModbusDiagSubFunction = 0x0C
+	DiagBusExceptionCount        ModbusDiagSubFunction = 0x0D
+	DiagServerMessageCount       ModbusDiagSubFunction = 0x0E
+	DiagServerNoResponseCount    ModbusDiagSubFunction = 0x0F
+	DiagServerNAKCount           ModbusDiagSubFunction = 0x10
+	DiagServerBusyCount          ModbusDiagSubFunction = 0x11
+	DiagBusCharOverrunCount      ModbusDiagSubFunction = 0x12
+	DiagClearOverrunCounter      ModbusDiagSubFunction = 0x14
+)
+
+// Max count of events in communication event log
+const commEventLogSize = 64
+
+// Events of communication event log
+const (
+	commEventRestart    byte = 0x00 // Communication restart
+	commEventListenOnly byte = 0x04 // Entered listen only mode
+	commEventReceive    byte = 0x80 // Message received
+	commEventSend       byte = 0x40 // Message sent
+	commEventBroadcast  byte = 0x40 // Receive event: broadcast received
+	commEventInListen   byte = 0x20 // Receive and send events: currently in listen only mode
+	commEventCommError  byte = 0x02 // Receive event: communication error
+)
+
+// Counters of serial line diagnostics
+type ModbusCounters struct {
+	BusMessages       uint16 // Messages detected on the bus
+	BusCommErrors     uint16 // CRC and framing errors
+	BusExceptions     uint16 // Exception answers
+	ServerMessages    uint16 // Messages addressed to server
+	ServerNoResponses uint16 // Messages addressed to server without answer
+	ServerNAKs        uint16 // Negative Acknowledge answers
+	ServerBusy        uint16 // Server Busy answers
+	BusCharOverruns   uint16 // Character overruns
+}
+
+// Communication event log
+type ModbusCommEventLog struct {
+	Status   uint16 // 0xFFFF if previous command is in progress
+	Events   uint16 // Communication event counter
+	Messages uint16 // Bus message count
+	Log      []byte // Events, the most recent first
+}
+
+// Diagnostics state of server
+type diagnostics struct {
+	mu         sync.Mutex
+	counters   ModbusCounters
+	events     uint16 // Communication event counter
+	log        []byte // Communication event log, the most recent first
+	listenOnly bool   // Server doesn't answer
+	register   uint16 // Diagnostic register
+}
+
+// Add event to communication event log
+func (d *diagnostics) addEvent(event byte) {
+	if len(d.log) < commEventLogSize {
+		d.log = append(d.log, 0)
+	}
+	copy(d.log[1:], d.log)
+	d.log[0] = event
+}
+
+// Count received request, returns true if server is in listen only mode
+func (d *diagnostics) received(request *ModbusPacket, addressed bool) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.counters.BusMessages++
+	if !addressed {
+		return d.listenOnly
+	}
+	d.counters.ServerMessages++
+	event := commEventReceive
+	if request.GetDevID() == 0 && request.TypeProtocol != ModbusTCP {
+		event |= commEventBroadcast
+	}
+	if d.listenOnly {
+		event |= commEventInListen
+	}
+	d.addEvent(event)
+	return d.listenOnly
+}
+
+// Count answer for request addressed to server, nil answer is not sent
+func (d *diagnostics) answered(request, answer *ModbusPacket) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if answer == nil {
+		d.counters.ServerNoResponses++
+	} else {
+		event := commEventSend
+		if answer.IsException() {
+			d.counters.BusExceptions++
+			switch code := answer.GetErrorCode(); {
+			case code <= ErrBadVal:
+				event |= 0x01
+			case code == ErrDeviceFailure:
+				event |= 0x02
+			case code == ErrAcknowledge || code == ErrBusy:
+				event |= 0x04
+			case code == ErrNAK:
+				event |= 0x08
+			}
+			switch answer.GetErrorCode() {
+			case ErrBusy:
+				d.counters.ServerBusy++
+			case ErrNAK:
+				d.counters.ServerNAKs++
+			}
+		}
+		d.addEvent(event)
+	}
+	// Counter of successful completions, event counter fetches are not counted
+	fc := request.GetFunctionCode()
+	if (answer == nil || !answer.IsException()) && fc != FcGetCommEventCounter && fc != FcGetCommEventLog {
+		d.events++
+	}
+}
+
+// Count communication error
+func (d *diagnostics) commError() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.counters.BusCommErrors++
+	d.addEvent(commEventReceive | commEventCommError)
+}
+
+// Clear counters and diagnostic register
+func (d *diagnostics) clear() {
+	d.counters = ModbusCounters{}
+	d.events = 0
+	d.register = 0
+}
+
+// Get counters of serial line diagnostics
+func (srv *ModbusServer) GetCounters() ModbusCounters {
+	srv.diag.mu.Lock()
+	defer srv.diag.mu.Unlock()
+	return srv.diag.counters
+}
+
+// Enter or leave listen only mode. In listen only mode requests are counted,
+// but not served and not answered, except Restart Communications which
+// leaves the mode.
+func (srv *ModbusServer) SetListenOnly(on bool) {
+	srv.diag.mu.Lock()
+	defer srv.diag.mu.Unlock()
+	if on && !srv.diag.listenOnly {
+		srv.diag.addEvent(commEventListenOnly)
+	}
+	srv.diag.listenOnly = on
+}
+
+// Checks that server is in listen only mode
+func (srv *ModbusServer) IsListenOnly() bool {
+	srv.diag.mu.Lock()
+	defer srv.diag.mu.Unlock()
+	return srv.diag.listenOnly
+}
+
+// Checks that request is Restart Communications, the only request served
+// in listen only mode
+func isRestartCommunications(mp *ModbusPacket) bool {
+	pdu := mp.GetRawPDU()
+	return len(pdu) >= 3 && ModbusFunctionCode(pdu[0]) == FcDiagnostics &&
+		ModbusDiagSubFunction(binary.BigEndian.Uint16(pdu[1:3])) == DiagRestartCommunications
+}
+
+// Checks length of Diagnostics data. Return Query Data echoes any count of
+// words, but length of RTU frame is predicted by one word of data. Other
+// sub-functions have one word of data.
+func diagDataFits(typeProtocol ModbusTypeProtocol, sub ModbusDiagSubFunction, n int) bool {
+	if sub != DiagReturnQueryData || typeProtocol == ModbusRTUviaTCP {
+		return n == 2
+	}
+	return n >= 2 && n%2 == 0
+}
+
+// Read Exception Status
+func (srv *ModbusServer) ReadExceptionStatus(mp *ModbusPacket) (*ModbusPacket, error) {
+	var status byte
+	if srv.ExceptionStatus != nil {
+		status = srv.ExceptionStatus()
+	}
+	return NewAnswer(mp, []byte{status}), nil
+}
+
+// Diagnostics
+func (srv *ModbusServer) Diagnostics(mp *ModbusPacket) (*ModbusPacket, error) {
+	pdu := mp.GetRawPDU()
+	if len(pdu) < 3 {
+		return buildErrAnswer(mp, ErrBadVal), errors.New("Bad length of request")
+	}
+	sub := ModbusDiagSubFunction(binary.BigEndian.Uint16(pdu[1:3]))
+	data := pdu[3:]
+	if !diagDataFits(mp.TypeProtocol, sub, len(data)) {
+		return buildErrAnswer(mp, ErrBadVal), errors.New("Bad length of request")
+	}
+
+	d := &srv.diag
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var value uint16
+	switch sub {
+	case DiagReturnQueryData:
+		return NewAnswer(mp, pdu[1:]), nil
+	case DiagRestartCommunications:
+		opt := binary.BigEndian.Uint16(data)
+		if opt != 0 && opt != 0xFF00 {
+			return buildErrAnswer(mp, ErrBadVal), fmt.Errorf("Bad data 0x%x of %d sub-function", opt, sub)
+		}
+		listenOnly := d.listenOnly
+		d.listenOnly = false
+		d.clear()
+		if opt == 0xFF00 {
+			d.log = nil
+		}
+		d.addEvent(commEventRestart)
+		// Server in listen only mode doesn't answer
+		if listenOnly {
+			return nil, nil
+		}
+		return NewAnswer(mp, pdu[1:]), nil
+	case DiagForceListenOnlyMode:
+		d.listenOnly = true
+		d.addEvent(commEventListenOnly)
+		return nil, nil
+	case DiagClearCounters:
+		d.clear()
+		return NewAnswer(mp, pdu[1:]), nil
+	case DiagClearOverrunCounter:
+		d.counters.BusCharOverruns = 0
+		return NewAnswer(mp, pdu[1:]), nil
+	case DiagReturnDiagnosticRegister:
+		value = d.register
+	case DiagBusMessageCount:
+		value = d.counters.BusMessages
+	case DiagBusCommErrorCount:
+		value = d.counters.BusCommErrors
+	case DiagBusExceptionCount:
+		value = d.counters.BusExceptions
+	case DiagServerMessageCount:
+		value = d.counters.ServerMessages
+	case DiagServerNoResponseCount:
+		value = d.counters.ServerNoResponses
+	case DiagServerNAKCount:
+		value = d.counters.ServerNAKs
+	case DiagServerBusyCount:
+		value = d.counters.ServerBusy
+	case DiagBusCharOverrunCount:
+		value = d.counters.BusCharOverruns
+	default:
+		return buildErrAnswer(mp, ErrCantHandel), fmt.Errorf("Unsupported sub-function %d", sub)
+	}
+	return NewAnswer(mp, wordArrToByteArr([]uint16{uint16(sub), value})), nil
+}
+
+// Get Comm Event Counter
+func (srv *ModbusServer) GetCommEventCounter(mp *ModbusPacket) (*ModbusPacket, error) {
+	srv.diag.mu.Lock()
+	defer srv.diag.mu.Unlock()
+	return NewAnswer(mp, wordArrToByteArr([]uint16{0, srv.diag.events})), nil
+}
+
+// Get Comm Event Log
+func (srv *ModbusServer) GetCommEventLog(mp *ModbusPacket) (*ModbusPacket, error) {
+	srv.diag.mu.Lock()
+	defer srv.diag.mu.Unlock()
+	data := wordArrToByteArr([]uint16{0, srv.diag.events, srv.diag.counters.BusMessages})
+	data = append(data, srv.diag.log...)
+	return NewAnswer(mp, append([]byte{byte(len(data))}, data...)), nil
+}
+
+// Report Server ID, answer contains server ID and run indicator status ON
+func (srv *ModbusServer) ReportServerId(mp *ModbusPacket) (*ModbusPacket, error) {
+	if srv.ServerId == nil {
+		return buildErrAnswer(mp, ErrCantHandel), errors.New("Server ID is not set")
+	}
+	// Byte count and run indicator status are added to server ID
+	if len(srv.ServerId) > MaxPDUDataSize-2 {
+		return buildErrAnswer(mp, ErrDeviceFailure), fmt.Errorf("Server ID is longer than %d bytes", MaxPDUDataSize-2)
+	}
+	// Server ID and run indicator status
+	data := append([]byte{byte(len(srv.ServerId) + 1)}, srv.ServerId...)
+	return NewAnswer(mp, append(data, 0xFF)), nil
+}
+
+// Send Request ReadExceptionStatus
+func (mc *ModbusClient) ReadExceptionStatus() (byte, error) {
+	return mc.ReadExceptionStatusContext(context.Background())
+}
+
+// Send Request ReadExceptionStatus, request is interrupted when ctx is done
+func (mc *ModbusClient) ReadExceptionStatusContext(ctx context.Context) (byte, error) {
+	pdu, err := mc.SendRawPDUContext(ctx, FcReadExceptionStatus, nil)
+	if err != nil {
+		return 0, err
+	}
+	if len(pdu) != 2 {
+		return 0, errors.New("Bad answer of ReadExceptionStatus")
+	}
+	return pdu[1], nil
+}
+
+// Send Request Diagnostics with sub-function and data, returns data of
+// answer
+func (mc *ModbusClient) Diagnostics(sub ModbusDiagSubFunction, data uint16) (uint16, error) {
+	return mc.DiagnosticsContext(context.Background(), sub, data)
+}
+
+// Send Request Diagnostics, request is interrupted when ctx is done
+func (mc *ModbusClient) DiagnosticsContext(ctx context.Context, sub ModbusDiagSubFunction, data uint16) (uint16, error) {
+	pdu, err := mc.SendRawPDUContext(ctx, FcDiagnostics, wordArrToByteArr([]uint16{uint16(sub), data}))
+	if err != nil {
+		return 0, err
+	}
+	if len(pdu) != 5 || ModbusDiagSubFunction(binary.BigEndian.Uint16(pdu[1:3])) != sub {
+		return 0, errors.New("Bad answer of Diagnostics")
+	}
+	return binary.BigEndian.Uint16(pdu[3:5]), nil
+}
+
+// Send Request Diagnostics Return Query Data, data must be echoed. RTU
+// allows one word of data only.
+func (mc *ModbusClient) ReturnQueryData(data ...uint16) error {
+	return mc.ReturnQueryDataContext(context.Background(), data...)
+}
+
+// Send Request Diagnostics Return Query Data, request is interrupted when
+// ctx is done
+func (mc *ModbusClient) ReturnQueryDataContext(ctx context.Context, data ...uint16) error {
+	if !diagDataFits(mc.TypeProtocol, DiagReturnQueryData, 2*len(data)) || 2+2*len(data) > MaxPDUDataSize {
+		return fmt.Errorf("Bad count %d of query data words", len(data))
+	}
+	req := wordArrToByteArr(append([]uint16{uint16(DiagReturnQueryData)}, data...))
+	pdu, err := mc.SendRawPDUContext(ctx, FcDiagnostics, req)
+	if err != nil {
+		return err
+	}
+	if len(pdu) < 1 || !bytes.Equal(pdu[1:], req) {
+		return fmt.Errorf("Expected echo % x, got % x", req, pdu)
+	}
+	return nil
+}
+
+// Send Request Diagnostics Restart Communications, communication event log
+// is cleared if clearLog is true. Server in listen only mode doesn't answer.
+func (mc *ModbusClient) RestartCommunications(clearLog bool) error {
+	return mc.RestartCommunicationsContext(context.Background(), clearLog)
+}
+
+// Send Request Diagnostics Restart Communications, request is interrupted
+// when ctx is done
+func (mc *ModbusClient) RestartCommunicationsContext(ctx context.Context, clearLog bool) error {
+	var data uint16
+	if clearLog {
+		data = 0xFF00
+	}
+	_, err := mc.DiagnosticsContext(ctx, DiagRestartCommunications, data)
+	return err
+}
+
+// Send Request Diagnostics Force Listen Only Mode, server doesn't answer
+// to this request
+func (mc *ModbusClient) ForceListenOnlyMode() error {
+	return mc.ForceListenOnlyModeContext(context.Background())
+}
+
+// Send Request Diagnostics Force Listen Only Mode, request is interrupted
+// when ctx is done
+func (mc *ModbusClient) ForceListenOnlyModeContext(ctx context.Context) error {
+	request := &ModbusPacket{}
+	request.Init(mc.TypeProtocol)
+	request.buildRawPDU(mc.DevID, FcDiagnostics, wordArrToByteArr([]uint16{uint16(DiagForceListenOnlyMode), 0}))
+	request.SetTransactionId(mc.GetTransactionId())
+	return mc.sendNoAnswer(ctx, request, 0)
+}
+
+// Send Request Diagnostics Clear Counters and Diagnostic Register
+func (mc *ModbusClient) ClearCounters() error {
+	return mc.ClearCountersContext(context.Background())
+}
+
+// Send Request Diagnostics Clear Counters and Diagnostic Register, request
+// is interrupted when ctx is done
+func (mc *ModbusClient) ClearCountersContext(ctx context.Context) error {
+	_, err := mc.DiagnosticsContext(ctx, DiagClearCounters, 0)
+	return err
+}
+
+// Send Request GetCommEventCounter, returns status and event counter
+func (mc *ModbusClient) GetCommEventCounter() (uint16, uint16, error) {
+	return mc.GetCommEventCounterContext(context.Background())
+}
+
+// Send Request GetCommEventCounter, request is interrupted when ctx is done
+func (mc *ModbusClient) GetCommEventCounterContext(ctx context.Context) (uint16, uint16, error) {
+	pdu, err := mc.SendRawPDUContext(ctx, FcGetCommEventCounter, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(pdu) != 5 {
+		return 0, 0, errors.New("Bad answer of GetCommEventCounter")
+	}
+	return binary.BigEndian.Uint16(pdu[1:3]), binary.BigEndian.Uint16(pdu[3:5]), nil
+}
+
+// Send Request GetCommEventLog
+func (mc *ModbusClient) GetCommEventLog() (*ModbusCommEventLog, error) {
+	return mc.GetCommEventLogContext(context.Background())
+}
+
+// Send Request GetCommEventLog, request is interrupted when ctx is done
+func (mc *ModbusClient) GetCommEventLogContext(ctx context.Context) (*ModbusCommEventLog, error) {
+	pdu, err := mc.SendRawPDUContext(ctx, FcGetCommEventLog, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(pdu) < 8 || int(pdu[1]) != len(pdu)-2 {
+		return nil, errors.New("Bad answer of GetCommEventLog")
+	}
+	return &ModbusCommEventLog{
+		Status:   binary.BigEndian.Uint16(pdu[2:4]),
+		Events:   binary.BigEndian.Uint16(pdu[4:6]),
+		Messages: binary.BigEndian.Uint16(pdu[6:8]),
+		Log:      append([]byte(nil), pdu[8:]...)}, nil
+}
+
+// Send Request ReportServerId, returns device specific data: server ID,
+// run indicator status and additional data
+func (mc *ModbusClient) ReportServerId() ([]byte, error) {
+	return mc.ReportServerIdContext(context.Background())
+}
+
+// Send Request ReportServerId, request is interrupted when ctx is done
+func (mc *ModbusClient) ReportServerIdContext(ctx context.Context) ([]byte, error) {
+	pdu, err := mc.SendRawPDUContext(ctx, FcReportServerId, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(pdu) < 2 || int(pdu[1]) != len(pdu)-2 {
+		return nil, errors.New("Bad answer of ReportServerId")
+	}
+	return append([]byte(nil), pdu[2:]...), nil
+}
@@ -0,0 +1,215 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDiagnostics_addEvent(t *testing.T) {
+	d := &diagnostics{}
+	for i := 0; i < commEventLogSize+10; i++ {
+		d.addEvent(byte(i))
+	}
+	if len(d.log) != commEventLogSize || d.log[0] != commEventLogSize+9 || d.log[commEventLogSize-1] != 10 {
+		t.Error("Expected", commEventLogSize, "events from", commEventLogSize+9, "got", d.log)
+	}
+}
+
+func TestModbusClient_Diagnostics(t *testing.T) {
+	md := new(ModbusData)
+	md.Init(10, 10, 10, 10)
+	srv := NewServer("127.0.0.1", "0", ModbusRTUviaTCP, md)
+	srv.ServerId = []byte{0x42}
+	srv.ExceptionStatus = func() byte { return 0x5A }
+	if err := srv.Start(); err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer srv.Stop()
+	_, port, _ := net.SplitHostPort(srv.ln.Addr().String())
+	cl, err := NewClient(port, "127.0.0.1", ModbusRTUviaTCP, 1)
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer cl.Close()
+	cl.Timeout = 100 * time.Millisecond
+
+	if status, err := cl.ReadExceptionStatus(); err != nil || status != 0x5A {
+		t.Error("Expected", 0x5A, "got", status, err)
+	}
+	if err = cl.ReturnQueryData(0xA537); err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	if id, err := cl.ReportServerId(); err != nil || !bytes.Equal(id, []byte{0x42, 0xFF}) {
+		t.Error("Expected [66 255], got", id, err)
+	}
+	if _, err = cl.ReadHoldingRegisters(10, 1); !errors.Is(err, ErrOutside) {
+		t.Error("Expected", ErrOutside, "got", err)
+	}
+	if cnt, err := cl.Diagnostics(DiagBusExceptionCount, 0); err != nil || cnt != 1 {
+		t.Error("Expected 1, got", cnt, err)
+	}
+	if cnt, err := cl.Diagnostics(DiagBusMessageCount, 0); err != nil || cnt != 6 {
+		t.Error("Expected 6, got", cnt, err)
+	}
+	// Exception and counter fetches are not counted
+	if status, cnt, err := cl.GetCommEventCounter(); err != nil || status != 0 || cnt != 5 {
+		t.Error("Expected 0 5, got", status, cnt, err)
+	}
+	evlog, err := cl.GetCommEventLog()
+	if err != nil || evlog.Events != 5 || evlog.Messages != 8 || len(evlog.Log) != 15 {
+		t.Fatal("Expected 5 events, 8 messages and 15 log entries, got", evlog, err)
+	}
+	if evlog.Log[0] != commEventReceive || evlog.Log[7] != commEventSend|0x01 {
+		t.Error("Expected receive and exception events, got", evlog.Log)
+	}
+	if _, err = cl.Diagnostics(0x33, 0); !errors.Is(err, ErrCantHandel) {
+		t.Error("Expected", ErrCantHandel, "got", err)
+	}
+
+	if err = cl.ClearCounters(); err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	if err = cl.ForceListenOnlyMode(); err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	if _, err = cl.ReadHoldingRegisters(0, 1); !isTimeout(err) {
+		t.Error("Expected timeout, got", err)
+	}
+	// Server in listen only mode doesn't answer to restart, silent answer
+	// is counted after clearing of counters
+	if err = cl.RestartCommunications(false); !isTimeout(err) {
+		t.Error("Expected timeout, got", err)
+	}
+	if _, err = cl.ReadHoldingRegisters(0, 1); err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	counters := srv.GetCounters()
+	if counters.BusMessages != 1 || counters.ServerMessages != 1 || counters.ServerNoResponses != 1 {
+		t.Error("Expected counters after restart, got", counters)
+	}
+}
+
+func TestModbusClient_DiagnosticsContext(t *testing.T) {
+	srv, cl := startTestServer(t, ModbusRTUviaTCP)
+	defer srv.Stop()
+	defer cl.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := cl.ReturnQueryDataContext(ctx, 0xA537); err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	cancel()
+	for _, err := range []error{
+		cl.ReturnQueryDataContext(ctx, 0xA537),
+		cl.RestartCommunicationsContext(ctx, false),
+		cl.ClearCountersContext(ctx),
+	} {
+		if err != context.Canceled {
+			t.Error("Expected", context.Canceled, "got", err)
+		}
+	}
+}
+
+type testServerDiagnosticspair struct {
+	mbprotocol ModbusTypeProtocol
+	data       []byte
+	echo       bool
+}
+
+func TestModbusServer_Diagnostics(t *testing.T) {
+	tests := []testServerDiagnosticspair{
+		{ModbusRTUviaTCP, []byte{0x0, 0x0, 0xA5, 0x37}, true},
+		// Query data longer than one word can't be framed on serial line
+		{ModbusRTUviaTCP, []byte{0x0, 0x0, 0xA5, 0x37, 0x12, 0x34}, false},
+		// ModbusTCP frame has length, any count of words is echoed
+		{ModbusTCP, []byte{0x0, 0x0, 0xA5, 0x37, 0x12, 0x34}, true},
+		{ModbusASCII, []byte{0x0, 0x0, 0xA5, 0x37, 0x12, 0x34}, true},
+		{ModbusTCP, []byte{0x0, 0x0, 0xA5, 0x37, 0x12}, false},
+		{ModbusTCP, []byte{0x0, 0x0}, false},
+		// Other sub-functions have one word of data
+		{ModbusTCP, []byte{0x0, 0xA, 0x0, 0x0, 0x12, 0x34}, false},
+	}
+	srv := &ModbusServer{}
+	for i, pair := range tests {
+		req := &ModbusPacket{}
+		req.Init(pair.mbprotocol)
+		req.buildRawPDU(1, FcDiagnostics, pair.data)
+		answ, err := srv.Diagnostics(req)
+		if pair.echo {
+			if err != nil || !bytes.Equal(answ.GetRawPDU(), req.GetRawPDU()) {
+				t.Error("For", i, "expected echo, got", answ.GetRawPDU(), err)
+			}
+		} else if answ.GetErrorCode() != ErrBadVal {
+			t.Error("For", i, "expected", ErrBadVal, "got", answ.GetException())
+		}
+	}
+}
+
+func TestModbusServer_ReportServerId(t *testing.T) {
+	srv := &ModbusServer{}
+	req := buildRequest(0, ModbusTCP, 1, FcReportServerId, 0, 0)
+	srv.ServerId = make([]byte, MaxPDUDataSize-2)
+	if answ, err := srv.ReportServerId(req); err != nil || len(answ.GetRawPDU()) != MaxPDUDataSize+1 {
+		t.Error("Expected answer of max size, got", answ.GetRawPDU(), err)
+	}
+	// Byte count can't hold longer server ID
+	srv.ServerId = make([]byte, MaxPDUDataSize-1)
+	if answ, err := srv.ReportServerId(req); err == nil || answ.GetErrorCode() != ErrDeviceFailure {
+		t.Error("Expected", ErrDeviceFailure, "got", answ.GetException(), err)
+	}
+}
+
+func TestModbusClient_ReturnQueryData(t *testing.T) {
+	for _, mbprotocol := range []ModbusTypeProtocol{ModbusTCP, ModbusRTUviaTCP, ModbusASCII} {
+		srv, cl := startTestServer(t, mbprotocol)
+		err := cl.ReturnQueryData(0xA537, 0x1234, 0x5678)
+		if mbprotocol == ModbusRTUviaTCP {
+			if err == nil {
+				t.Error("For", mbprotocol, "expected error, got nil")
+			}
+		} else if err != nil {
+			t.Error("For", mbprotocol, "expected nil, got", err)
+		}
+		if err = cl.ReturnQueryData(); err == nil {
+			t.Error("For", mbprotocol, "expected error, got nil")
+		}
+		cl.Close()
+		srv.Stop()
+	}
+}
+
+func TestModbusServer_SetListenOnly(t *testing.T) {
+	md := new(ModbusData)
+	md.Init(10, 10, 10, 10)
+	srv := &ModbusServer{}
+	srv.Data = md
+	srv.SetListenOnly(true)
+	if !srv.IsListenOnly() {
+		t.Error("Expected true, got false")
+	}
+	// Request is counted, but not served
+	req := buildRequest(0, ModbusRTUviaTCP, 1, FcPresetSingleRegister, 0, 5)
+	if answ := srv.serveRequest(context.Background(), req); answ != nil {
+		t.Error("Expected nil, got", answ)
+	}
+	if res, _ := md.ReadHoldingRegisters(0, 1); res[0] != 0 {
+		t.Error("Expected 0, got", res)
+	}
+	if counters := srv.GetCounters(); counters.ServerMessages != 1 || counters.ServerNoResponses != 1 {
+		t.Error("Expected 1 message without response, got", counters)
+	}
+	srv.SetListenOnly(false)
+	if answ := srv.serveRequest(context.Background(), req); answ == nil || answ.IsException() {
+		t.Error("Expected answer, got", answ)
+	}
+	if len(srv.diag.log) != 4 || srv.diag.log[2] != commEventReceive|commEventInListen || srv.diag.log[3] != commEventListenOnly {
+		t.Error("Expected receive, send and listen only events, got", srv.diag.log)
+	}
+}
@@ -6,26 +6,94 @@ package modbus
 
 import (
 	"errors"
+	"fmt"
 )
 
-// Modbus Error code
+// Modbus Error code (exception code)
 type ModbusErrors int
 
 const (
-	ErrCantHandel ModbusErrors = 1
-	ErrOutside    ModbusErrors = 2
-	ErrBadVal     ModbusErrors = 3
+	ErrCantHandel    ModbusErrors = 0x01 // Illegal Function
+	ErrOutside       ModbusErrors = 0x02 // Illegal Data Address
+	ErrBadVal        ModbusErrors = 0x03 // Illegal Data Value
+	ErrDeviceFailure ModbusErrors = 0x04 // Server Device Failure
+	ErrAcknowledge   ModbusErrors = 0x05 // Acknowledge, long duration request is accepted
+	ErrBusy          ModbusErrors = 0x06 // Server Device Busy
+	ErrNAK           ModbusErrors = 0x07 // Negative Acknowledge
+	ErrMemoryParity  ModbusErrors = 0x08 // Memory Parity Error
+	ErrGatewayPath   ModbusErrors = 0x0A // Gateway Path Unavailable
+	ErrGatewayTarget ModbusErrors = 0x0B // Gateway Target Device Failed to Respond
 )
 
-func (e ModbusErrors) Error() error {
+func (e ModbusErrors) Error() string {
 	switch e {
 	case ErrCantHandel:
-		return errors.New("Can't handel request")
+		return "Can't handel request"
 	case ErrOutside:
-		return errors.New("Requested outside valid range")
+		return "Requested outside valid range"
 	case ErrBadVal:
-		return errors.New("Bad value in request")
+		return "Bad value in request"
+	case ErrDeviceFailure:
+		return "Server device failure"
+	case ErrAcknowledge:
+		return "Request is accepted, processing takes long time"
+	case ErrBusy:
+		return "Server device is busy"
+	case ErrNAK:
+		return "Negative acknowledge"
+	case ErrMemoryParity:
+		return "Memory parity error"
+	case ErrGatewayPath:
+		return "Gateway path unavailable"
+	case ErrGatewayTarget:
+		return "Gateway target device failed to respond"
 	default:
-		return errors.New("Unknown Error")
+		return "Unknown Error"
 	}
 }
+
+// ExceptionError is returned by ModbusClient when server answers by exception
+type ExceptionError struct {
+	FunctionCode  ModbusFunctionCode // Function code of request
+	ExceptionCode ModbusErrors       // Exception code from answer
+}
+
+func (e *ExceptionError) Error() string {
+	return fmt.Sprintf("Exception 0x%02x for function %s(0x%02x): %s",
+		int(e.ExceptionCode), e.FunctionCode, byte(e.FunctionCode), e.ExceptionCode.Error())
+}
+
+// Unwrap returns exception code, so errors.Is(err, ErrBusy) can be used
+func (e *ExceptionError) Unwrap() error {
+	return e.ExceptionCode
+}
+
+// Errors of answer which isn't answer to request
+var (
+	ErrUnitIdMismatch       = errors.New("Unit ID of answer doesn't match request")
+	ErrFunctionCodeMismatch = errors.New("Function code of answer doesn't match request")
+)
+
+// MismatchError is returned by ModbusClient when unit ID or function code of
+// answer doesn't match request
+type MismatchError struct {
+	Err      error // ErrUnitIdMismatch or ErrFunctionCodeMismatch
+	Expected byte  // Field of request
+	Got      byte  // Field of answer
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("%s: expected 0x%02x, got 0x%02x", e.Err.Error(), e.Expected, e.Got)
+}
+
+// Unwrap returns mismatch error, so errors.Is(err, ErrUnitIdMismatch) can be
+// used
+func (e *MismatchError) Unwrap() error {
+	return e.Err
+}
+
+// Checks that error is caused by expired deadline
+func isTimeout(err error) bool {
+	te, ok := err.(interface{ Timeout() bool })
+	return ok && te.Timeout()
+}
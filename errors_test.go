@@ -0,0 +1,49 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type testModbusErrorspair struct {
+	code ModbusErrors
+	msg  string
+}
+
+var testsModbusErrors = []testModbusErrorspair{
+	{ErrCantHandel, "Can't handel request"},
+	{ErrOutside, "Requested outside valid range"},
+	{ErrBusy, "Server device is busy"},
+	{ErrNAK, "Negative acknowledge"},
+	{ErrGatewayTarget, "Gateway target device failed to respond"},
+	{ModbusErrors(0x7F), "Unknown Error"},
+}
+
+func TestModbusErrors_Error(t *testing.T) {
+	for _, pair := range testsModbusErrors {
+		if pair.code.Error() != pair.msg {
+			t.Error("Expected ", pair.msg, "got ", pair.code.Error())
+		}
+	}
+}
+
+func TestExceptionError(t *testing.T) {
+	var err error = &ExceptionError{FunctionCode: FcReadHoldingRegisters, ExceptionCode: ErrBusy}
+	err = fmt.Errorf("Request failed: %w", err)
+
+	var exc *ExceptionError
+	if !errors.As(err, &exc) {
+		t.Fatal("Expected ExceptionError, got", err)
+	}
+	if exc.FunctionCode != FcReadHoldingRegisters || exc.ExceptionCode != ErrBusy {
+		t.Error("Expected", FcReadHoldingRegisters, ErrBusy, "got", exc.FunctionCode, exc.ExceptionCode)
+	}
+	if !errors.Is(err, ErrBusy) {
+		t.Error("Expected errors.Is for", ErrBusy)
+	}
+}
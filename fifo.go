@@ -0,0 +1,145 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Max count of registers in FIFO queue
+const MaxFIFOCount = 31
+
+// ModbusFIFO is queue of registers read by Read FIFO Queue
+type ModbusFIFO struct {
+	mu     sync.Mutex
+	values []uint16
+}
+
+// Push values to the end of queue, nothing is pushed if queue overflows
+func (f *ModbusFIFO) Push(values ...uint16) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.values)+len(values) > MaxFIFOCount {
+		return fmt.Errorf("FIFO overflow, %d of %d registers are queued", len(f.values), MaxFIFOCount)
+	}
+	f.values = append(f.values, values...)
+	return nil
+}
+
+// Pop value from the head of queue
+func (f *ModbusFIFO) Pop() (uint16, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.values) == 0 {
+		return 0, errors.New("FIFO is empty")
+	}
+	value := f.values[0]
+	f.values = f.values[1:]
+	return value, nil
+}
+
+// Remove all values from queue
+func (f *ModbusFIFO) Clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.values = nil
+}
+
+// Get copy of queued values
+func (f *ModbusFIFO) Get() []uint16 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]uint16{}, f.values...)
+}
+
+// ModbusFIFOReader is implemented by data store with FIFO queues, otherwise
+// Read FIFO Queue answers Illegal Function
+type ModbusFIFOReader interface {
+	ReadFIFOQueue(addr uint16) ([]uint16, error)
+}
+
+// Add empty FIFO queue at pointer address, previous queue at address is
+// replaced
+func (md *ModbusData) AddFIFO(addr uint16) *ModbusFIFO {
+	md.mu_fifos.Lock()
+	defer md.mu_fifos.Unlock()
+	if md.fifos == nil {
+		md.fifos = make(map[uint16]*ModbusFIFO)
+	}
+	f := &ModbusFIFO{}
+	md.fifos[addr] = f
+	return f
+}
+
+// Get FIFO queue at pointer address, nil if there is no queue
+func (md *ModbusData) GetFIFO(addr uint16) *ModbusFIFO {
+	md.mu_fifos.Lock()
+	defer md.mu_fifos.Unlock()
+	return md.fifos[addr]
+}
+
+// Read FIFO Queue, queue isn't cleared by reading
+func (md *ModbusData) ReadFIFOQueue(addr uint16) ([]uint16, error) {
+	f := md.GetFIFO(addr)
+	if f == nil {
+		return nil, fmt.Errorf("%w: FIFO at address %d not found", ErrOutside, addr)
+	}
+	return f.Get(), nil
+}
+
+// Length of Read FIFO Queue answer, byte count has two bytes
+func fifoLength(pdu []byte) int {
+	if len(pdu) < 3 {
+		return 0
+	}
+	return 3 + int(binary.BigEndian.Uint16(pdu[1:3]))
+}
+
+// Read FIFO Queue
+func (srv *ModbusServer) ReadFIFOQueue(mp *ModbusPacket) (*ModbusPacket, error) {
+	pdu := mp.GetRawPDU()
+	if len(pdu) != 3 {
+		return buildErrAnswer(mp, ErrBadVal), errors.New("Bad length of request")
+	}
+	fr, ok := srv.unitData(mp).(ModbusFIFOReader)
+	if !ok {
+		return buildErrAnswer(mp, ErrCantHandel), errors.New("Data store has no FIFO queues")
+	}
+	values, err := fr.ReadFIFOQueue(binary.BigEndian.Uint16(pdu[1:3]))
+	if err != nil {
+		return buildErrAnswer(mp, storeErrorCode(err)), err
+	}
+	if len(values) > MaxFIFOCount {
+		return buildErrAnswer(mp, ErrBadVal), fmt.Errorf("FIFO count %d is greater than %d", len(values), MaxFIFOCount)
+	}
+	// Byte count, FIFO count and values
+	data := wordArrToByteArr(append([]uint16{uint16(2 + 2*len(values)), uint16(len(values))}, values...))
+	return NewAnswer(mp, data), nil
+}
+
+// Send Request ReadFIFOQueue
+func (mc *ModbusClient) ReadFIFOQueue(addr uint16) ([]uint16, error) {
+	return mc.ReadFIFOQueueContext(context.Background(), addr)
+}
+
+// Send Request ReadFIFOQueue, request is interrupted when ctx is done
+func (mc *ModbusClient) ReadFIFOQueueContext(ctx context.Context, addr uint16) ([]uint16, error) {
+	pdu, err := mc.SendRawPDUContext(ctx, FcReadFIFOQueue, wordArrToByteArr([]uint16{addr}))
+	if err != nil {
+		return nil, err
+	}
+	if len(pdu) < 5 || fifoLength(pdu) != len(pdu) {
+		return nil, errors.New("Bad byte count in answer")
+	}
+	cnt := int(binary.BigEndian.Uint16(pdu[3:5]))
+	if cnt > MaxFIFOCount || 2*cnt != len(pdu)-5 {
+		return nil, fmt.Errorf("Bad FIFO count %d in answer", cnt)
+	}
+	return byteArrToWordArr(pdu[5:]), nil
+}
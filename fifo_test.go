@@ -0,0 +1,70 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestModbusFIFO(t *testing.T) {
+	f := &ModbusFIFO{}
+	if _, err := f.Pop(); err == nil {
+		t.Error("Expected error, got nil")
+	}
+	if err := f.Push(make([]uint16, MaxFIFOCount-1)...); err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	if err := f.Push(1, 2); err == nil {
+		t.Error("Expected error, got nil")
+	}
+	if err := f.Push(3); err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	if res := f.Get(); len(res) != MaxFIFOCount || res[MaxFIFOCount-1] != 3 {
+		t.Error("Expected", MaxFIFOCount, "values, got", res)
+	}
+	if value, err := f.Pop(); err != nil || value != 0 {
+		t.Error("Expected 0, got", value, err)
+	}
+	f.Clear()
+	if res := f.Get(); len(res) != 0 {
+		t.Error("Expected empty FIFO, got", res)
+	}
+}
+
+func TestModbusData_ReadFIFOQueue(t *testing.T) {
+	md := &ModbusData{}
+	if _, err := md.ReadFIFOQueue(0x10); err == nil {
+		t.Error("Expected error, got nil")
+	}
+	md.AddFIFO(0x10).Push(5, 6)
+	if res, err := md.ReadFIFOQueue(0x10); err != nil || len(res) != 2 || res[1] != 6 {
+		t.Error("Expected [5 6], got", res, err)
+	}
+	// Queue isn't cleared by reading
+	if res := md.GetFIFO(0x10).Get(); len(res) != 2 {
+		t.Error("Expected [5 6], got", res)
+	}
+}
+
+func TestModbusClient_ReadFIFOQueue(t *testing.T) {
+	for _, mbprotocol := range []ModbusTypeProtocol{ModbusTCP, ModbusRTUviaTCP, ModbusASCII} {
+		srv, cl := startTestServer(t, mbprotocol)
+		f := srv.Data.(*ModbusData).AddFIFO(0x4D2)
+		if res, err := cl.ReadFIFOQueue(0x4D2); err != nil || len(res) != 0 {
+			t.Error("For", mbprotocol, "expected empty FIFO, got", res, err)
+		}
+		f.Push(0x1B8, 0x1284)
+		if res, err := cl.ReadFIFOQueue(0x4D2); err != nil || len(res) != 2 || res[0] != 0x1B8 || res[1] != 0x1284 {
+			t.Error("For", mbprotocol, "expected [440 4740], got", res, err)
+		}
+		if _, err := cl.ReadFIFOQueue(0x4D3); !errors.Is(err, ErrOutside) {
+			t.Error("For", mbprotocol, "expected", ErrOutside, "got", err)
+		}
+		cl.Close()
+		srv.Stop()
+	}
+}
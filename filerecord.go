@@ -0,0 +1,330 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Max count of records in file, record numbers are 0...9999
+const MaxFileRecords = 10000
+
+// Reference type of file record sub-request
+const fileRefType byte = 0x06
+
+// Limits of file record PDU
+const (
+	fileByteCountMax    = 0xF5                       // Max byte count of request and answer
+	fileSubRequestSize  = 7                          // Reference type, file, record and length
+	maxReadFileRecords  = (fileByteCountMax - 2) / 2 // Records of one read sub-request
+	maxWriteFileRecords = (fileByteCountMax - 7) / 2 // Records of one write sub-request
+)
+
+// Records of file in sub-request
+type ModbusFileRecord struct {
+	File   uint16   // File number, starts from 1
+	Record uint16   // Starting record number
+	Cnt    uint16   // Count of records for reading
+	Data   []uint16 // Records which are read or written
+}
+
+// Storage of files for file record access
+type ModbusFileStore interface {
+	ReadFileRecord(file, record, cnt uint16) ([]uint16, error)
+	WriteFileRecord(file, record uint16, data []uint16) error
+}
+
+// ModbusFiles keeps files in memory, file grows on writing up to
+// MaxFileRecords records
+type ModbusFiles struct {
+	mu    sync.RWMutex
+	files map[uint16][]uint16
+}
+
+// Checks that records are inside of file address space
+func checkFileRange(file, record uint16, cnt int) error {
+	if file == 0 {
+		return errors.New("File number 0 is not valid")
+	}
+	if int(record)+cnt > MaxFileRecords {
+		return fmt.Errorf("Requested records %d...%d outside the valid range 0...%d", record, int(record)+cnt, MaxFileRecords)
+	}
+	return nil
+}
+
+// Read records of file
+func (mf *ModbusFiles) ReadFileRecord(file, record, cnt uint16) ([]uint16, error) {
+	if err := checkFileRange(file, record, int(cnt)); err != nil {
+		return nil, err
+	}
+	mf.mu.RLock()
+	defer mf.mu.RUnlock()
+	data, ok := mf.files[file]
+	if !ok {
+		return nil, fmt.Errorf("File %d not found", file)
+	}
+	if int(record)+int(cnt) > len(data) {
+		return nil, fmt.Errorf("Requested records %d...%d outside the file %d of %d records", record, int(record)+int(cnt), file, len(data))
+	}
+	return append([]uint16(nil), data[record:record+cnt]...), nil
+}
+
+// Write records of file, missing file is created
+func (mf *ModbusFiles) WriteFileRecord(file, record uint16, data []uint16) error {
+	if err := checkFileRange(file, record, len(data)); err != nil {
+		return err
+	}
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	if mf.files == nil {
+		mf.files = make(map[uint16][]uint16)
+	}
+	f := mf.files[file]
+	if end := int(record) + len(data); end > len(f) {
+		f = append(f, make([]uint16, end-len(f))...)
+	}
+	copy(f[record:], data)
+	mf.files[file] = f
+	return nil
+}
+
+// Get copy of all records of file, nil if file is not found
+func (mf *ModbusFiles) GetFile(file uint16) []uint16 {
+	mf.mu.RLock()
+	defer mf.mu.RUnlock()
+	if data, ok := mf.files[file]; ok {
+		return append([]uint16{}, data...)
+	}
+	return nil
+}
+
+// Replace all records of file
+func (mf *ModbusFiles) SetFile(file uint16, data ...uint16) error {
+	if err := checkFileRange(file, 0, len(data)); err != nil {
+		return err
+	}
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	if mf.files == nil {
+		mf.files = make(map[uint16][]uint16)
+	}
+	mf.files[file] = append([]uint16{}, data...)
+	return nil
+}
+
+// Checks byte count of file record PDU
+func checkFileByteCount(pdu []byte) error {
+	if len(pdu) < 2 || int(pdu[1]) != len(pdu)-2 {
+		return errors.New("Byte count doesn't match length of PDU")
+	}
+	if pdu[1] < fileSubRequestSize || pdu[1] > fileByteCountMax {
+		return fmt.Errorf("Byte count %d outside the valid range %d...%d", pdu[1], fileSubRequestSize, fileByteCountMax)
+	}
+	return nil
+}
+
+// Parse header of file record sub-request
+func parseFileSubRequest(sub []byte) (file, record, cnt uint16, err error) {
+	if sub[0] != fileRefType {
+		return 0, 0, 0, fmt.Errorf("Bad reference type %d", sub[0])
+	}
+	file = binary.BigEndian.Uint16(sub[1:3])
+	record = binary.BigEndian.Uint16(sub[3:5])
+	cnt = binary.BigEndian.Uint16(sub[5:7])
+	return file, record, cnt, checkFileRange(file, record, int(cnt))
+}
+
+// Read File Record
+func (srv *ModbusServer) ReadFileRecord(mp *ModbusPacket) (*ModbusPacket, error) {
+	if srv.Files == nil {
+		return buildErrAnswer(mp, ErrCantHandel), errors.New("Files are not set")
+	}
+	pdu := mp.GetRawPDU()
+	if err := checkFileByteCount(pdu); err != nil {
+		return buildErrAnswer(mp, ErrBadVal), err
+	}
+	if pdu[1]%fileSubRequestSize != 0 {
+		return buildErrAnswer(mp, ErrBadVal), errors.New("Byte count isn't multiple of sub-request size")
+	}
+
+	data := []byte{0}
+	for sub := pdu[2:]; len(sub) > 0; sub = sub[fileSubRequestSize:] {
+		file, record, cnt, err := parseFileSubRequest(sub)
+		if err != nil {
+			return buildErrAnswer(mp, ErrOutside), err
+		}
+		if len(data)+2+2*int(cnt) > fileByteCountMax+1 {
+			return buildErrAnswer(mp, ErrBadVal), errors.New("Answer is too long")
+		}
+		records, err := srv.Files.ReadFileRecord(file, record, cnt)
+		if err != nil {
+			return buildErrAnswer(mp, ErrOutside), err
+		}
+		data = append(data, byte(1+2*len(records)), fileRefType)
+		data = append(data, wordArrToByteArr(records)...)
+	}
+	data[0] = byte(len(data) - 1)
+	return NewAnswer(mp, data), nil
+}
+
+// Write File Record, all sub-requests are checked before writing
+func (srv *ModbusServer) WriteFileRecord(mp *ModbusPacket) (*ModbusPacket, error) {
+	if srv.Files == nil {
+		return buildErrAnswer(mp, ErrCantHandel), errors.New("Files are not set")
+	}
+	pdu := mp.GetRawPDU()
+	if err := checkFileByteCount(pdu); err != nil {
+		return buildErrAnswer(mp, ErrBadVal), err
+	}
+
+	var records []ModbusFileRecord
+	for sub := pdu[2:]; len(sub) > 0; {
+		if len(sub) < fileSubRequestSize {
+			return buildErrAnswer(mp, ErrBadVal), errors.New("Bad length of sub-request")
+		}
+		file, record, cnt, err := parseFileSubRequest(sub)
+		if len(sub) < fileSubRequestSize+2*int(cnt) {
+			return buildErrAnswer(mp, ErrBadVal), errors.New("Bad length of sub-request")
+		}
+		if err != nil {
+			return buildErrAnswer(mp, ErrOutside), err
+		}
+		end := fileSubRequestSize + 2*int(cnt)
+		records = append(records, ModbusFileRecord{file, record, cnt, byteArrToWordArr(sub[fileSubRequestSize:end])})
+		sub = sub[end:]
+	}
+	for _, r := range records {
+		if err := srv.Files.WriteFileRecord(r.File, r.Record, r.Data); err != nil {
+			return buildErrAnswer(mp, ErrOutside), err
+		}
+	}
+	// Answer is echo of request
+	return NewAnswer(mp, pdu[1:]), nil
+}
+
+// Header of file record sub-request
+func fileSubRequest(file, record, cnt uint16) []byte {
+	return append([]byte{fileRefType}, wordArrToByteArr([]uint16{file, record, cnt})...)
+}
+
+// Send Request ReadFileRecord, records are read by one request, Cnt of
+// every sub-request is read into Data of returned records
+func (mc *ModbusClient) ReadFileRecords(records ...ModbusFileRecord) ([]ModbusFileRecord, error) {
+	return mc.ReadFileRecordsContext(context.Background(), records...)
+}
+
+// Send Request ReadFileRecord, request is interrupted when ctx is done
+func (mc *ModbusClient) ReadFileRecordsContext(ctx context.Context, records ...ModbusFileRecord) ([]ModbusFileRecord, error) {
+	req := []byte{byte(fileSubRequestSize * len(records))}
+	for _, r := range records {
+		req = append(req, fileSubRequest(r.File, r.Record, r.Cnt)...)
+	}
+	if len(records) == 0 || len(req)-1 > fileByteCountMax {
+		return nil, fmt.Errorf("Count of sub-requests %d outside the valid range 1...%d", len(records), fileByteCountMax/fileSubRequestSize)
+	}
+	pdu, err := mc.SendRawPDUContext(ctx, FcReadFileRecord, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(pdu) < 2 || int(pdu[1]) != len(pdu)-2 {
+		return nil, errors.New("Bad byte count in answer")
+	}
+
+	res := make([]ModbusFileRecord, len(records))
+	answer := pdu[2:]
+	for i, r := range records {
+		if len(answer) < 2 || int(answer[0]) != 1+2*int(r.Cnt) || len(answer) < int(answer[0])+1 || answer[1] != fileRefType {
+			return nil, fmt.Errorf("Bad answer of sub-request %d", i)
+		}
+		r.Data = byteArrToWordArr(answer[2 : answer[0]+1])
+		res[i] = r
+		answer = answer[answer[0]+1:]
+	}
+	if len(answer) != 0 {
+		return nil, errors.New("Bad length of answer")
+	}
+	return res, nil
+}
+
+// Send Request WriteFileRecord, Data of records is written by one request
+func (mc *ModbusClient) WriteFileRecords(records ...ModbusFileRecord) error {
+	return mc.WriteFileRecordsContext(context.Background(), records...)
+}
+
+// Send Request WriteFileRecord, request is interrupted when ctx is done
+func (mc *ModbusClient) WriteFileRecordsContext(ctx context.Context, records ...ModbusFileRecord) error {
+	req := []byte{0}
+	for _, r := range records {
+		req = append(req, fileSubRequest(r.File, r.Record, uint16(len(r.Data)))...)
+		req = append(req, wordArrToByteArr(r.Data)...)
+	}
+	if len(records) == 0 || len(req)-1 > fileByteCountMax {
+		return fmt.Errorf("Length of request %d outside the valid range %d...%d", len(req)-1, fileSubRequestSize, fileByteCountMax)
+	}
+	req[0] = byte(len(req) - 1)
+	pdu, err := mc.SendRawPDUContext(ctx, FcWriteFileRecord, req)
+	if err != nil {
+		return err
+	}
+	if len(pdu) < 1 || !bytes.Equal(pdu[1:], req) {
+		return errors.New("Answer isn't echo of request")
+	}
+	return nil
+}
+
+// Send Request ReadFileRecord, records are read by several requests when
+// count of records doesn't fit into one
+func (mc *ModbusClient) ReadFileRecord(file, record, cnt uint16) ([]uint16, error) {
+	return mc.ReadFileRecordContext(context.Background(), file, record, cnt)
+}
+
+// Send Request ReadFileRecord, request is interrupted when ctx is done
+func (mc *ModbusClient) ReadFileRecordContext(ctx context.Context, file, record, cnt uint16) ([]uint16, error) {
+	if err := checkFileRange(file, record, int(cnt)); err != nil {
+		return nil, err
+	}
+	data := make([]uint16, 0, cnt)
+	for len(data) < int(cnt) {
+		chunk := int(cnt) - len(data)
+		if chunk > maxReadFileRecords {
+			chunk = maxReadFileRecords
+		}
+		res, err := mc.ReadFileRecordsContext(ctx, ModbusFileRecord{File: file, Record: record + uint16(len(data)), Cnt: uint16(chunk)})
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, res[0].Data...)
+	}
+	return data, nil
+}
+
+// Send Request WriteFileRecord, records are written by several requests
+// when data doesn't fit into one
+func (mc *ModbusClient) WriteFileRecord(file, record uint16, data ...uint16) error {
+	return mc.WriteFileRecordContext(context.Background(), file, record, data...)
+}
+
+// Send Request WriteFileRecord, request is interrupted when ctx is done
+func (mc *ModbusClient) WriteFileRecordContext(ctx context.Context, file, record uint16, data ...uint16) error {
+	if err := checkFileRange(file, record, len(data)); err != nil {
+		return err
+	}
+	for pos := 0; pos < len(data); pos += maxWriteFileRecords {
+		end := pos + maxWriteFileRecords
+		if end > len(data) {
+			end = len(data)
+		}
+		err := mc.WriteFileRecordsContext(ctx, ModbusFileRecord{File: file, Record: record + uint16(pos), Data: data[pos:end]})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
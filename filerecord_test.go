@@ -0,0 +1,155 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+type testModbusFilespair struct {
+	file, record, cnt uint16
+	ok                bool
+}
+
+var testsModbusFiles = []testModbusFilespair{
+	{1, 0, 4, true},
+	{1, 2, 2, true},
+	{1, 3, 2, false},
+	{2, 0, 1, false},
+	{0, 0, 1, false},
+	{1, 9999, 2, false},
+}
+
+func TestModbusFiles_ReadFileRecord(t *testing.T) {
+	mf := &ModbusFiles{}
+	if err := mf.SetFile(1, 1, 2, 3, 4); err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	for _, pair := range testsModbusFiles {
+		res, err := mf.ReadFileRecord(pair.file, pair.record, pair.cnt)
+		if (err == nil) != pair.ok {
+			t.Error("For", pair, "expected ok", pair.ok, "got", err)
+			continue
+		}
+		if pair.ok && (len(res) != int(pair.cnt) || res[0] != pair.record+1) {
+			t.Error("For", pair, "got", res)
+		}
+	}
+}
+
+func TestModbusFiles_WriteFileRecord(t *testing.T) {
+	mf := &ModbusFiles{}
+	if err := mf.WriteFileRecord(3, 2, []uint16{7, 8}); err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	if err := mf.WriteFileRecord(3, 0, []uint16{5}); err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	if res := mf.GetFile(3); len(res) != 4 || res[0] != 5 || res[1] != 0 || res[3] != 8 {
+		t.Error("Expected [5 0 7 8], got", res)
+	}
+	if err := mf.WriteFileRecord(3, MaxFileRecords-1, []uint16{1, 2}); err == nil {
+		t.Error("Expected error, got nil")
+	}
+	if res := mf.GetFile(4); res != nil {
+		t.Error("Expected nil, got", res)
+	}
+}
+
+type testFileRecordRequestpair struct {
+	fc  ModbusFunctionCode
+	req []byte
+	err ModbusErrors
+}
+
+var testsFileRecordRequest = []testFileRecordRequestpair{
+	{FcReadFileRecord, []byte{0x7, 0x6, 0x0, 0x1, 0x0, 0x0, 0x0, 0x2}, 0},
+	{FcReadFileRecord, []byte{0x6, 0x6, 0x0, 0x1, 0x0, 0x0, 0x0}, ErrBadVal},
+	{FcReadFileRecord, []byte{0x8, 0x6, 0x0, 0x1, 0x0, 0x0, 0x0, 0x2}, ErrBadVal},
+	{FcReadFileRecord, []byte{0x7, 0x5, 0x0, 0x1, 0x0, 0x0, 0x0, 0x2}, ErrOutside},
+	{FcReadFileRecord, []byte{0x7, 0x6, 0x0, 0x2, 0x0, 0x0, 0x0, 0x1}, ErrOutside},
+	{FcReadFileRecord, []byte{0x7, 0x6, 0x0, 0x1, 0x27, 0x10, 0x0, 0x0}, ErrOutside},
+	{FcWriteFileRecord, []byte{0x9, 0x6, 0x0, 0x2, 0x0, 0x1, 0x0, 0x1, 0x0, 0x5}, 0},
+	{FcWriteFileRecord, []byte{0x9, 0x6, 0x0, 0x2, 0x0, 0x1, 0x0, 0x2, 0x0, 0x5}, ErrBadVal},
+	{FcWriteFileRecord, []byte{0x9, 0x6, 0x0, 0x0, 0x0, 0x1, 0x0, 0x1, 0x0, 0x5}, ErrOutside},
+}
+
+func TestModbusServer_FileRecord(t *testing.T) {
+	srv := &ModbusServer{}
+	req := &ModbusPacket{}
+	req.Init(ModbusTCP)
+	req.buildRawPDU(1, FcReadFileRecord, testsFileRecordRequest[0].req)
+	if answ, _ := srv.ReadFileRecord(req); answ.GetErrorCode() != ErrCantHandel {
+		t.Error("Expected", ErrCantHandel, "got", answ.GetException())
+	}
+
+	files := &ModbusFiles{}
+	files.SetFile(1, 0x1234, 0x5678)
+	srv.Files = files
+	for _, pair := range testsFileRecordRequest {
+		req.buildRawPDU(1, pair.fc, pair.req)
+		var answ *ModbusPacket
+		if pair.fc == FcReadFileRecord {
+			answ, _ = srv.ReadFileRecord(req)
+		} else {
+			answ, _ = srv.WriteFileRecord(req)
+		}
+		if answ.IsException() != (pair.err != 0) || (pair.err != 0 && answ.GetErrorCode() != pair.err) {
+			t.Error("For", pair, "expected exception", pair.err, "got", answ.GetException())
+		}
+	}
+	if res := files.GetFile(2); len(res) != 2 || res[1] != 5 {
+		t.Error("Expected [0 5], got", res)
+	}
+}
+
+func TestModbusClient_FileRecord(t *testing.T) {
+	for _, mbprotocol := range []ModbusTypeProtocol{ModbusTCP, ModbusRTUviaTCP, ModbusASCII} {
+		srv := NewServer("127.0.0.1", "0", mbprotocol, new(ModbusData))
+		srv.Files = &ModbusFiles{}
+		if err := srv.Start(); err != nil {
+			t.Fatal("Expected nil, got", err)
+		}
+		_, port, _ := net.SplitHostPort(srv.ln.Addr().String())
+		cl, err := NewClient(port, "127.0.0.1", mbprotocol, 1)
+		if err != nil {
+			srv.Stop()
+			t.Fatal("Expected nil, got", err)
+		}
+
+		// Data doesn't fit into one request
+		test_data := make([]uint16, 300)
+		for i := range test_data {
+			test_data[i] = uint16(i)
+		}
+		if err = cl.WriteFileRecord(5, 10, test_data...); err != nil {
+			t.Error("For", mbprotocol, "expected nil, got", err)
+		}
+		res, err := cl.ReadFileRecord(5, 10, 300)
+		if err != nil || len(res) != len(test_data) || res[0] != 0 || res[299] != 299 {
+			t.Error("For", mbprotocol, "expected", len(test_data), "records, got", len(res), err)
+		}
+
+		// Several sub-requests in one request
+		err = cl.WriteFileRecords(ModbusFileRecord{File: 6, Data: []uint16{1, 2}}, ModbusFileRecord{File: 7, Record: 1, Data: []uint16{3}})
+		if err != nil {
+			t.Error("For", mbprotocol, "expected nil, got", err)
+		}
+		records, err := cl.ReadFileRecords(ModbusFileRecord{File: 6, Record: 1, Cnt: 1}, ModbusFileRecord{File: 7, Cnt: 2})
+		if err != nil || len(records) != 2 || records[0].Data[0] != 2 || records[1].Data[1] != 3 {
+			t.Error("For", mbprotocol, "expected [2] [0 3], got", records, err)
+		}
+		if _, err = cl.ReadFileRecords(ModbusFileRecord{File: 8, Cnt: 1}); !errors.Is(err, ErrOutside) {
+			t.Error("For", mbprotocol, "expected", ErrOutside, "got", err)
+		}
+		if _, err = cl.ReadFileRecord(5, MaxFileRecords-1, 2); err == nil {
+			t.Error("For", mbprotocol, "expected error, got nil")
+		}
+		cl.Close()
+		srv.Stop()
+	}
+}
@@ -0,0 +1,358 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Frame errors
+var (
+	ErrFrameIncomplete = errors.New("Frame is incomplete, silent interval between characters")
+	ErrFrameTooLong    = errors.New("Frame is too long")
+	ErrBadProtocolId   = errors.New("Bad protocol identifier in MBAP header")
+	ErrBadMBAPLength   = errors.New("Bad length field in MBAP header")
+	ErrBadASCIIFrame   = errors.New("Bad ModbusASCII frame")
+	ErrBadChecksum     = errors.New("Bad checksum of frame")
+)
+
+// Size of MBAP header with unit identifier
+const mbapHeaderSize = 7
+
+// Silent intervals for baud rates above 19200, Modbus over Serial Line
+// Specification 2.5.1.1
+const (
+	rtuFixedT15 = 750 * time.Microsecond
+	rtuFixedT35 = 1750 * time.Microsecond
+)
+
+// Reads whole frames from connection
+type frameReader interface {
+	ReadFrame(mp *ModbusPacket) error
+}
+
+// Make frame reader suitable for connection and protocol
+func newFrameReader(conn io.Reader, typeProtocol ModbusTypeProtocol, isAnswer bool) frameReader {
+	switch typeProtocol {
+	case ModbusRTUviaTCP:
+		fr := &rtuFrameReader{isAnswer: isAnswer}
+		if port, ok := conn.(*SerialPort); ok {
+			fr.port = port
+			fr.t15, fr.t35 = port.Config.silentIntervals()
+		} else {
+			fr.rd = bufio.NewReaderSize(conn, typeProtocol.MaxSize())
+		}
+		return fr
+	case ModbusASCII:
+		// Hex encoded frame with start colon and end CR LF
+		return &asciiFrameReader{rd: bufio.NewReaderSize(conn, 2*typeProtocol.MaxSize()+3)}
+	default:
+		return &tcpFrameReader{rd: bufio.NewReaderSize(conn, typeProtocol.MaxSize())}
+	}
+}
+
+// Checks that error is caused by broken frame, so next frame can be read
+// from the same connection
+func isFrameError(err error) bool {
+	return err == ErrFrameIncomplete || err == ErrFrameTooLong || err == ErrBadASCIIFrame
+}
+
+// Silent intervals t1.5 and t3.5 for serial line
+func (cfg *SerialConfig) silentIntervals() (time.Duration, time.Duration) {
+	if cfg.BaudRate > 19200 || cfg.BaudRate <= 0 {
+		return rtuFixedT15, rtuFixedT35
+	}
+	// Character is 11 bits: start, 8 data, parity or second stop, stop
+	char := time.Duration(11) * time.Second / time.Duration(cfg.BaudRate)
+	return char * 3 / 2, char * 7 / 2
+}
+
+// Expected PDU length (function code and data) predicted by first bytes
+// of PDU. Returns 0 if more bytes are needed for prediction.
+func pduLength(pdu []byte, isAnswer bool) (int, error) {
+	if len(pdu) == 0 {
+		return 0, nil
+	}
+	fc := ModbusFunctionCode(pdu[0])
+	if isAnswer && byte(fc)&0x80 != 0 {
+		return 2, nil
+	}
+	switch fc {
+	case FcReadCoilStatus, FcReadDescreteInputs, FcReadHoldingRegisters, FcReadInputRegisters:
+		if isAnswer {
+			return byteCountLength(pdu, 1), nil
+		}
+		return 5, nil
+	case FcForceSingleCoil, FcPresetSingleRegister:
+		return 5, nil
+	case FcForceMultipleCoils, FcPresetMultipleRegisters:
+		if isAnswer {
+			return 5, nil
+		}
+		return byteCountLength(pdu, 5), nil
+	case FcReadExceptionStatus:
+		if isAnswer {
+			return 2, nil
+		}
+		return 1, nil
+	case FcDiagnostics:
+		// Sub-function and one word of data
+		return 5, nil
+	case FcGetCommEventCounter:
+		if isAnswer {
+			return 5, nil
+		}
+		return 1, nil
+	case FcReadFileRecord, FcWriteFileRecord:
+		return byteCountLength(pdu, 1), nil
+	case FcGetCommEventLog, FcReportServerId:
+		if isAnswer {
+			return byteCountLength(pdu, 1), nil
+		}
+		return 1, nil
+	case FcMaskWriteRegister:
+		return 7, nil
+	case FcReadWriteMultipleRegisters:
+		if isAnswer {
+			return byteCountLength(pdu, 1), nil
+		}
+		return byteCountLength(pdu, 9), nil
+	case FcReadFIFOQueue:
+		if isAnswer {
+			return fifoLength(pdu), nil
+		}
+		return 3, nil
+	case FcEncapsulatedInterface:
+		return meiLength(pdu, isAnswer)
+	default:
+		if n, ok := registeredLength(pdu, isAnswer); ok {
+			return n, nil
+		}
+		return 0, fmt.Errorf("Can't predict length of function %s(0x%x)", fc, byte(fc))
+	}
+}
+
+// PDU length for PDU with byte count field at pos followed by data,
+// 0 if byte count is not received yet
+func byteCountLength(pdu []byte, pos int) int {
+	if len(pdu) <= pos {
+		return 0
+	}
+	return pos + 1 + int(pdu[pos])
+}
+
+// Reads ModbusTCP frames, frame is delimited by length field of MBAP header.
+// Buffered reader keeps pipelined frames received in one segment.
+type tcpFrameReader struct {
+	rd *bufio.Reader
+}
+
+// Frame is peeked before it is consumed, so frame which is not received
+// completely at timeout stays buffered for next read
+func (fr *tcpFrameReader) ReadFrame(mp *ModbusPacket) error {
+	mp.Length = 0
+	header, err := fr.rd.Peek(mbapHeaderSize)
+	if err != nil {
+		return err
+	}
+	if binary.BigEndian.Uint16(header[2:4]) != 0 {
+		return ErrBadProtocolId
+	}
+	// Length field counts unit identifier and PDU
+	total := mp.TypeProtocol.Offset() + int(binary.BigEndian.Uint16(header[4:6]))
+	if total < mbapHeaderSize+1 || total > len(mp.PDU) {
+		return ErrBadMBAPLength
+	}
+	frame, err := fr.rd.Peek(total)
+	if err != nil {
+		return err
+	}
+	copy(mp.PDU, frame)
+	fr.rd.Discard(total)
+	mp.Length = total
+	return nil
+}
+
+// Reads RTU frames. On serial line frames are delimited by silent
+// intervals, in other cases the length of frame is predicted by function code.
+type rtuFrameReader struct {
+	port     *SerialPort   // Serial port for silent intervals mode
+	rd       *bufio.Reader // Buffered reader for length prediction mode
+	t15, t35 time.Duration // Silent intervals for serial line
+	isAnswer bool          // Read answers or requests
+	tooLong  bool          // Frame is longer than max size
+}
+
+func (fr *rtuFrameReader) ReadFrame(mp *ModbusPacket) error {
+	mp.Length = 0
+	if fr.rd == nil {
+		return fr.readBySilence(mp)
+	}
+	return fr.readByLength(mp)
+}
+
+// Delimit frame by predicted length
+func (fr *rtuFrameReader) readByLength(mp *ModbusPacket) error {
+	// Device address and function code
+	if _, err := io.ReadFull(fr.rd, mp.PDU[:2]); err != nil {
+		return err
+	}
+	mp.Length = 2
+	for {
+		n, err := pduLength(mp.PDU[1:mp.Length], fr.isAnswer)
+		if err != nil {
+			// Unknown function, take all what was received
+			n = fr.rd.Buffered()
+			if mp.Length+n > len(mp.PDU) {
+				return ErrFrameTooLong
+			}
+			mp.Length += copy(mp.PDU[mp.Length:], fr.take(n))
+			return nil
+		}
+		if n == 0 {
+			if mp.Length >= len(mp.PDU) {
+				return ErrFrameTooLong
+			}
+			if _, err = io.ReadFull(fr.rd, mp.PDU[mp.Length:mp.Length+1]); err != nil {
+				return err
+			}
+			mp.Length++
+			continue
+		}
+		// Device address + PDU + CRC
+		total := 1 + n + 2
+		if total > len(mp.PDU) {
+			return ErrFrameTooLong
+		}
+		if total < mp.Length {
+			return fmt.Errorf("Predicted frame length %d is less than %d received bytes", total, mp.Length)
+		}
+		if _, err = io.ReadFull(fr.rd, mp.PDU[mp.Length:total]); err != nil {
+			return err
+		}
+		mp.Length = total
+		return nil
+	}
+}
+
+// Get and discard n buffered bytes
+func (fr *rtuFrameReader) take(n int) []byte {
+	data, _ := fr.rd.Peek(n)
+	fr.rd.Discard(len(data))
+	return data
+}
+
+// Delimit frame by silent intervals
+func (fr *rtuFrameReader) readBySilence(mp *ModbusPacket) error {
+	// Clear deadlines set for silent intervals
+	defer fr.port.SetReadDeadline(time.Time{})
+
+	// Wait first characters with deadline set by caller
+	n, err := fr.port.Read(mp.PDU)
+	if err != nil {
+		return err
+	}
+	mp.Length = n
+	fr.tooLong = false
+	incomplete := false
+	for {
+		fr.port.SetReadDeadline(time.Now().Add(fr.t15))
+		n, err = fr.read(mp)
+		if err == nil {
+			mp.Length += n
+			continue
+		}
+		if !isTimeout(err) {
+			return err
+		}
+		// Silence is longer than t1.5, the frame is ended if silence lasts t3.5
+		fr.port.SetReadDeadline(time.Now().Add(fr.t35 - fr.t15))
+		n, err = fr.read(mp)
+		if err == nil {
+			// Characters after t1.5 silence, frame must be discarded
+			incomplete = true
+			mp.Length += n
+			continue
+		}
+		if !isTimeout(err) {
+			return err
+		}
+		break
+	}
+	if incomplete {
+		return ErrFrameIncomplete
+	}
+	if fr.tooLong {
+		return ErrFrameTooLong
+	}
+	return nil
+}
+
+// Read next characters of frame, characters beyond max size are dropped
+func (fr *rtuFrameReader) read(mp *ModbusPacket) (int, error) {
+	if mp.Length >= len(mp.PDU) {
+		var buf [64]byte
+		_, err := fr.port.Read(buf[:])
+		if err == nil {
+			fr.tooLong = true
+		}
+		return 0, err
+	}
+	return fr.port.Read(mp.PDU[mp.Length:])
+}
+
+// Reads ModbusASCII frames, frame starts with colon and ends with CR LF
+type asciiFrameReader struct {
+	rd *bufio.Reader
+}
+
+func (fr *asciiFrameReader) ReadFrame(mp *ModbusPacket) error {
+	mp.Length = 0
+	// Skip everything before start of frame
+	for {
+		b, err := fr.rd.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b == ':' {
+			break
+		}
+	}
+	line, err := fr.rd.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return ErrFrameTooLong
+	}
+	if err != nil {
+		return err
+	}
+	// Colon always starts new frame, previous characters are discarded
+	if i := bytes.LastIndexByte(line, ':'); i >= 0 {
+		line = line[i+1:]
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return ErrBadASCIIFrame
+	}
+	line = line[:len(line)-2]
+	// Device address, function code and LRC at least
+	if len(line)%2 != 0 || len(line) < 6 {
+		return ErrBadASCIIFrame
+	}
+	if len(line)/2 > len(mp.PDU) {
+		return ErrFrameTooLong
+	}
+	n, err := hex.Decode(mp.PDU, line)
+	if err != nil {
+		return ErrBadASCIIFrame
+	}
+	mp.Length = n
+	return nil
+}
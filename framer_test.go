@@ -0,0 +1,241 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+type testpduLengthpair struct {
+	pdu      []byte
+	isAnswer bool
+	length   int
+}
+
+var testspduLength = []testpduLengthpair{
+	{[]byte{}, false, 0},
+	{[]byte{0x3}, false, 5},
+	{[]byte{0x3}, true, 0},
+	{[]byte{0x3, 0x4}, true, 6},
+	{[]byte{0x83}, true, 2},
+	{[]byte{0x5}, true, 5},
+	{[]byte{0x7}, false, 1},
+	{[]byte{0x7}, true, 2},
+	{[]byte{0x8}, true, 5},
+	{[]byte{0xB}, true, 5},
+	{[]byte{0xC}, false, 1},
+	{[]byte{0xC, 0x8}, true, 10},
+	{[]byte{0x11, 0x2}, true, 4},
+	{[]byte{0x14, 0x7}, false, 9},
+	{[]byte{0x15, 0x9}, true, 11},
+	{[]byte{0x10, 0x0, 0x0, 0x0, 0x2}, false, 0},
+	{[]byte{0x10, 0x0, 0x0, 0x0, 0x2, 0x4}, false, 10},
+	{[]byte{0x10}, true, 5},
+	{[]byte{0x16}, false, 7},
+	{[]byte{0x16}, true, 7},
+	{[]byte{0x17, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x0, 0x1}, false, 0},
+	{[]byte{0x17, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x0, 0x1, 0x2}, false, 12},
+	{[]byte{0x17, 0x2}, true, 4},
+	{[]byte{0x18}, false, 3},
+	{[]byte{0x18, 0x0}, true, 0},
+	{[]byte{0x18, 0x0, 0x6}, true, 9},
+}
+
+func TestPduLength(t *testing.T) {
+	for _, pair := range testspduLength {
+		res, err := pduLength(pair.pdu, pair.isAnswer)
+		if err != nil || res != pair.length {
+			t.Error("For", pair.pdu, "expected", pair.length, "got", res, err)
+		}
+	}
+	if _, err := pduLength([]byte{0x64}, false); err == nil {
+		t.Error("Expected error for unknown function, got nil")
+	}
+}
+
+type testsilentIntervalspair struct {
+	baud     int
+	t15, t35 time.Duration
+}
+
+var testssilentIntervals = []testsilentIntervalspair{
+	{9600, 1718749 * time.Nanosecond, 4010415 * time.Nanosecond},
+	{19200, 859374 * time.Nanosecond, 2005206 * time.Nanosecond},
+	{115200, 750 * time.Microsecond, 1750 * time.Microsecond},
+}
+
+func TestSerialConfig_silentIntervals(t *testing.T) {
+	for _, pair := range testssilentIntervals {
+		cfg := &SerialConfig{BaudRate: pair.baud}
+		t15, t35 := cfg.silentIntervals()
+		if t15 != pair.t15 || t35 != pair.t35 {
+			t.Error("For", pair.baud, "expected", pair.t15, pair.t35, "got", t15, t35)
+		}
+	}
+}
+
+func TestRtuFrameReader_readByLength(t *testing.T) {
+	srv, cl := net.Pipe()
+	defer srv.Close()
+	defer cl.Close()
+
+	req1 := buildRequest(0, ModbusRTUviaTCP, 1, FcReadHoldingRegisters, 0, 2)
+	req2 := buildRequest(0, ModbusRTUviaTCP, 2, FcPresetMultipleRegisters, 0, 2, 0x0, 0x1, 0x0, 0x2)
+	stream := append(append([]byte{}, req1.PDU[:req1.GetPDULength()]...), req2.PDU[:req2.GetPDULength()]...)
+	go func() {
+		// Fragmented and back-to-back frames
+		cl.Write(stream[:3])
+		cl.Write(stream[3:11])
+		cl.Write(stream[11:])
+	}()
+
+	fr := newFrameReader(srv, ModbusRTUviaTCP, false)
+	for _, req := range []*ModbusPacket{req1, req2} {
+		mp := &ModbusPacket{}
+		mp.Init(ModbusRTUviaTCP)
+		if err := fr.ReadFrame(mp); err != nil {
+			t.Fatal("Expected nil, got", err)
+		}
+		if mp.Length != req.Length {
+			t.Fatal("Expected", req.Length, "got", mp.Length)
+		}
+		for i, v := range req.PDU[:req.Length] {
+			if mp.PDU[i] != v {
+				t.Error("Expected", v, "got", mp.PDU[i])
+			}
+		}
+	}
+}
+
+type testbadLengthpair struct {
+	fc     ModbusFunctionCode
+	length LengthFunc
+}
+
+func TestRtuFrameReader_badLength(t *testing.T) {
+	tests := []testbadLengthpair{
+		// Length is never predicted
+		{0x42, func(pdu []byte) int { return 0 }},
+		// Predicted length is less than received bytes
+		{0x43, func(pdu []byte) int {
+			if len(pdu) < 4 {
+				return 0
+			}
+			return 1
+		}},
+	}
+	for _, pair := range tests {
+		// Broken rule is installed bypassing checks of RegisterFunction
+		functionsMu.Lock()
+		functions[pair.fc] = &ModbusFunction{RequestLength: pair.length}
+		functionsMu.Unlock()
+		srv, cl := net.Pipe()
+		go func() {
+			stream := make([]byte, 300)
+			stream[0], stream[1] = 1, byte(pair.fc)
+			cl.Write(stream)
+		}()
+		mp := &ModbusPacket{}
+		mp.Init(ModbusRTUviaTCP)
+		if err := newFrameReader(srv, ModbusRTUviaTCP, false).ReadFrame(mp); err == nil {
+			t.Error("For", pair.fc, "expected error, got nil")
+		}
+		srv.Close()
+		cl.Close()
+		UnregisterFunction(pair.fc)
+	}
+}
+
+func TestTcpFrameReader(t *testing.T) {
+	srv, cl := net.Pipe()
+	defer srv.Close()
+	defer cl.Close()
+
+	req1 := buildRequest(1, ModbusTCP, 1, FcReadHoldingRegisters, 0, 2)
+	req2 := buildRequest(2, ModbusTCP, 1, FcPresetMultipleRegisters, 0, 2, 0x0, 0x1, 0x0, 0x2)
+	req3 := buildRequest(3, ModbusTCP, 1, FcReadCoilStatus, 0, 8)
+	stream := append(append([]byte{}, req1.PDU[:req1.GetPDULength()]...), req2.PDU[:req2.GetPDULength()]...)
+	stream = append(stream, req3.PDU[:req3.GetPDULength()]...)
+	go func() {
+		// Two pipelined ADUs in one segment, third one is split
+		split := req1.GetPDULength() + req2.GetPDULength() + 4
+		cl.Write(stream[:split])
+		cl.Write(stream[split:])
+		// Bad protocol identifier
+		cl.Write([]byte{0x0, 0x4, 0x0, 0x1, 0x0, 0x6, 0x1, 0x3, 0x0, 0x0, 0x0, 0x1})
+	}()
+
+	fr := newFrameReader(srv, ModbusTCP, false)
+	for _, req := range []*ModbusPacket{req1, req2, req3} {
+		mp := &ModbusPacket{}
+		mp.Init(ModbusTCP)
+		if err := fr.ReadFrame(mp); err != nil {
+			t.Fatal("Expected nil, got", err)
+		}
+		if mp.GetPDULength() != req.GetPDULength() {
+			t.Fatal("Expected", req.GetPDULength(), "got", mp.GetPDULength())
+		}
+		if mp.GetTransactionId() != req.GetTransactionId() {
+			t.Error("Expected", req.GetTransactionId(), "got", mp.GetTransactionId())
+		}
+		for i, v := range req.PDU[:req.GetPDULength()] {
+			if mp.PDU[i] != v {
+				t.Error("Expected", v, "got", mp.PDU[i])
+			}
+		}
+	}
+
+	mp := &ModbusPacket{}
+	mp.Init(ModbusTCP)
+	if err := fr.ReadFrame(mp); err != ErrBadProtocolId {
+		t.Error("Expected", ErrBadProtocolId, "got", err)
+	}
+}
+
+func TestAsciiFrameReader(t *testing.T) {
+	srv, cl := net.Pipe()
+	defer srv.Close()
+	defer cl.Close()
+
+	go func() {
+		// Garbage before frame, frame split to parts
+		cl.Write([]byte("xx:1103006B"))
+		cl.Write([]byte("00037E\r\n"))
+		// Colon restarts frame
+		cl.Write([]byte(":0103:1103006B00037E\r\n"))
+		// Bad characters and missing CR
+		cl.Write([]byte(":11ZZ006B00037E\r\n:1103006B00037E\n"))
+	}()
+
+	fr := newFrameReader(srv, ModbusASCII, false)
+	target := []byte{0x11, 0x3, 0x0, 0x6B, 0x0, 0x3, 0x7E}
+	for i := 0; i < 2; i++ {
+		mp := &ModbusPacket{}
+		mp.Init(ModbusASCII)
+		if err := fr.ReadFrame(mp); err != nil {
+			t.Fatal("Expected nil, got", err)
+		}
+		if mp.Length != len(target) {
+			t.Fatal("Expected", len(target), "got", mp.Length)
+		}
+		for i, v := range target {
+			if mp.PDU[i] != v {
+				t.Error("Expected", v, "got", mp.PDU[i])
+			}
+		}
+		if !mp.IsLrcGood() {
+			t.Error("Expected good LRC")
+		}
+	}
+	for i := 0; i < 2; i++ {
+		mp := &ModbusPacket{}
+		mp.Init(ModbusASCII)
+		if err := fr.ReadFrame(mp); err != ErrBadASCIIFrame {
+			t.Error("Expected", ErrBadASCIIFrame, "got", err)
+		}
+	}
+}
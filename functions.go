@@ -6,22 +6,51 @@ package modbus
 
 import (
 	"encoding/binary"
+	"fmt"
 )
 
 // Type of Modbus function
 type ModbusFunctionCode byte
 
 const (
-	FcReadCoilStatus          ModbusFunctionCode = 0x01
-	FcReadDescreteInputs      ModbusFunctionCode = 0x02
-	FcReadHoldingRegisters    ModbusFunctionCode = 0x03
-	FcReadInputRegisters      ModbusFunctionCode = 0x04
-	FcForceSingleCoil         ModbusFunctionCode = 0x05
-	FcPresetSingleRegister    ModbusFunctionCode = 0x06
-	FcForceMultipleCoils      ModbusFunctionCode = 0x0F
-	FcPresetMultipleRegisters ModbusFunctionCode = 0x10
+	FcReadCoilStatus             ModbusFunctionCode = 0x01
+	FcReadDescreteInputs         ModbusFunctionCode = 0x02
+	FcReadHoldingRegisters       ModbusFunctionCode = 0x03
+	FcReadInputRegisters         ModbusFunctionCode = 0x04
+	FcForceSingleCoil            ModbusFunctionCode = 0x05
+	FcPresetSingleRegister       ModbusFunctionCode = 0x06
+	FcReadExceptionStatus        ModbusFunctionCode = 0x07
+	FcDiagnostics                ModbusFunctionCode = 0x08
+	FcGetCommEventCounter        ModbusFunctionCode = 0x0B
+	FcGetCommEventLog            ModbusFunctionCode = 0x0C
+	FcForceMultipleCoils         ModbusFunctionCode = 0x0F
+	FcPresetMultipleRegisters    ModbusFunctionCode = 0x10
+	FcReportServerId             ModbusFunctionCode = 0x11
+	FcReadFileRecord             ModbusFunctionCode = 0x14
+	FcWriteFileRecord            ModbusFunctionCode = 0x15
+	FcMaskWriteRegister          ModbusFunctionCode = 0x16
+	FcReadWriteMultipleRegisters ModbusFunctionCode = 0x17
+	FcReadFIFOQueue              ModbusFunctionCode = 0x18
+	FcEncapsulatedInterface      ModbusFunctionCode = 0x2B
 )
 
+// Quantity limits of requests
+const (
+	MaxReadCoils          = 2000 // Read Coil Status and Read Descrete Inputs
+	MaxReadRegisters      = 125  // Read Holding and Input Registers
+	MaxWriteCoils         = 1968 // Force Multiple Coils
+	MaxWriteRegisters     = 123  // Preset Multiple Registers
+	MaxReadWriteRegisters = 121  // Write part of Read/Write Multiple Registers
+)
+
+// Checks that quantity of request is inside the valid range 1...max
+func checkQuantity(cnt uint16, max int) error {
+	if cnt == 0 || int(cnt) > max {
+		return fmt.Errorf("Quantity %d outside the valid range 1...%d", cnt, max)
+	}
+	return nil
+}
+
 // Get the name of this function
 func (fc ModbusFunctionCode) String() string {
 	switch fc {
@@ -37,11 +66,36 @@ func (fc ModbusFunctionCode) String() string {
 		return "ForceSingleCoil"
 	case FcPresetSingleRegister:
 		return "PresetSingleRegister"
+	case FcReadExceptionStatus:
+		return "ReadExceptionStatus"
+	case FcDiagnostics:
+		return "Diagnostics"
+	case FcGetCommEventCounter:
+		return "GetCommEventCounter"
+	case FcGetCommEventLog:
+		return "GetCommEventLog"
 	case FcForceMultipleCoils:
 		return "ForceMultipleCoils"
 	case FcPresetMultipleRegisters:
 		return "PresetMultipleRegisters"
+	case FcReportServerId:
+		return "ReportServerId"
+	case FcReadFileRecord:
+		return "ReadFileRecord"
+	case FcWriteFileRecord:
+		return "WriteFileRecord"
+	case FcMaskWriteRegister:
+		return "MaskWriteRegister"
+	case FcReadWriteMultipleRegisters:
+		return "ReadWriteMultipleRegisters"
+	case FcReadFIFOQueue:
+		return "ReadFIFOQueue"
+	case FcEncapsulatedInterface:
+		return "EncapsulatedInterface"
 	default:
+		if f := lookupFunction(fc); f != nil && f.Name != "" {
+			return f.Name
+		}
 		return "Unknown"
 	}
 }
@@ -86,22 +140,14 @@ func boolArrToByteArr(data []bool) []byte {
 	return byte_data
 }
 
-// Convert byte array to bool array
-func byteArrToBoolArr(data []byte, cnt byte) []bool {
+// Convert byte array to bool array, missing bits are false
+func byteArrToBoolArr(data []byte, cnt uint16) []bool {
 	bool_data := make([]bool, cnt)
-	j := byte(0)
-	for _, value := range data {
-		for k := uint(0); k < 8; k++ {
-			bool_data[j] = bool(value&byte(1<<k) != 0)
-			j++
-			if j == cnt {
-				break
-			}
-
-		}
-		if j == cnt {
+	for i := range bool_data {
+		if i/8 >= len(data) {
 			break
 		}
+		bool_data[i] = data[i/8]&byte(1<<uint(i%8)) != 0
 	}
 	return bool_data
 }
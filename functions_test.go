@@ -21,8 +21,19 @@ var testsModbusFunctionCodeString = []testModbusFunctionCodeStringpair{
 	{FcReadInputRegisters, "ReadInputRegisters"},
 	{FcForceSingleCoil, "ForceSingleCoil"},
 	{FcPresetSingleRegister, "PresetSingleRegister"},
+	{FcReadExceptionStatus, "ReadExceptionStatus"},
+	{FcDiagnostics, "Diagnostics"},
+	{FcGetCommEventCounter, "GetCommEventCounter"},
+	{FcGetCommEventLog, "GetCommEventLog"},
 	{FcForceMultipleCoils, "ForceMultipleCoils"},
 	{FcPresetMultipleRegisters, "PresetMultipleRegisters"},
+	{FcReportServerId, "ReportServerId"},
+	{FcReadFileRecord, "ReadFileRecord"},
+	{FcWriteFileRecord, "WriteFileRecord"},
+	{FcMaskWriteRegister, "MaskWriteRegister"},
+	{FcReadWriteMultipleRegisters, "ReadWriteMultipleRegisters"},
+	{FcReadFIFOQueue, "ReadFIFOQueue"},
+	{FcEncapsulatedInterface, "EncapsulatedInterface"},
 }
 
 func TestModbusFunctionCode_String(t *testing.T) {
@@ -64,7 +75,7 @@ func TestwordArrToByteArr(t *testing.T) {
 
 func TestbyteArrToBoolArr(t *testing.T) {
 	test_data := []byte{0x19}
-	test_cnt := byte(5)
+	test_cnt := uint16(5)
 	target := []bool{true, true, false, false, true}
 	res := byteArrToBoolArr(test_data, test_cnt)
 	for i, v := range target {
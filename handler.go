@@ -0,0 +1,167 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Handler serves requests of ModbusServer. Answer may contain exception,
+// nil answer is not sent. Error describes failure of request for logging.
+type Handler interface {
+	ServeModbus(ctx context.Context, request *ModbusPacket) (*ModbusPacket, error)
+}
+
+// HandlerFunc allows to use function as Handler
+type HandlerFunc func(ctx context.Context, request *ModbusPacket) (*ModbusPacket, error)
+
+// Call f(ctx, request)
+func (f HandlerFunc) ServeModbus(ctx context.Context, request *ModbusPacket) (*ModbusPacket, error) {
+	return f(ctx, request)
+}
+
+// Middleware wraps handler with additional behavior
+type Middleware func(next Handler) Handler
+
+// Wrap handler with middlewares, first middleware is outermost
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// Keys of request context values
+type contextKey int
+
+const (
+	remoteAddrKey contextKey = iota
+	roleKey
+)
+
+// Get address of client from request context
+func RemoteAddrFromContext(ctx context.Context) net.Addr {
+	addr, _ := ctx.Value(remoteAddrKey).(net.Addr)
+	return addr
+}
+
+// Get Modbus role of client from request context, empty role if
+// connection is not secured
+func RoleFromContext(ctx context.Context) string {
+	role, _ := ctx.Value(roleKey).(string)
+	return role
+}
+
+// Build context of requests from client
+func peerContext(addr net.Addr, role string) context.Context {
+	ctx := context.WithValue(context.Background(), remoteAddrKey, addr)
+	return context.WithValue(ctx, roleKey, role)
+}
+
+// Logging middleware logs function code, result and duration of requests
+func Logging(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, request *ModbusPacket) (*ModbusPacket, error) {
+		start := time.Now()
+		answer, err := next.ServeModbus(ctx, request)
+		result := "OK"
+		switch {
+		case err != nil:
+			result = err.Error()
+		case answer == nil:
+			result = "No answer"
+		}
+		log.Printf("Src->: %s, %s, %s, %s\n",
+			RemoteAddrFromContext(ctx), request.GetFunctionCode(), result, time.Since(start))
+		return answer, err
+	})
+}
+
+// Authorization middleware allows requests by rules for role of client,
+// Illegal Function exception is answered to other requests. Without rules
+// all requests are authorized.
+func Authorization(rules ...ModbusRule) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, request *ModbusPacket) (*ModbusPacket, error) {
+			role := RoleFromContext(ctx)
+			if !isAuthorized(rules, role, request) {
+				return buildErrAnswer(request, ErrCantHandel), fmt.Errorf("Role %s is not authorized", role)
+			}
+			return next.ServeModbus(ctx, request)
+		})
+	}
+}
+
+// RateLimit middleware limits requests per second with burst of requests,
+// Server Busy exception is answered to requests over limit
+func RateLimit(rps float64, burst int) Middleware {
+	var (
+		mu     sync.Mutex
+		tokens = float64(burst)
+		last   = time.Now()
+	)
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, request *ModbusPacket) (*ModbusPacket, error) {
+			mu.Lock()
+			now := time.Now()
+			tokens += now.Sub(last).Seconds() * rps
+			if tokens > float64(burst) {
+				tokens = float64(burst)
+			}
+			last = now
+			allowed := tokens >= 1
+			if allowed {
+				tokens--
+			}
+			mu.Unlock()
+			if !allowed {
+				return buildErrAnswer(request, ErrBusy), fmt.Errorf("Rate limit is exceeded")
+			}
+			return next.ServeModbus(ctx, request)
+		})
+	}
+}
+
+// ModbusMetrics counts requests served by handler
+type ModbusMetrics struct {
+	requests   uint64
+	exceptions uint64
+	silent     uint64
+}
+
+// Middleware counting requests
+func (m *ModbusMetrics) Middleware(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, request *ModbusPacket) (*ModbusPacket, error) {
+		answer, err := next.ServeModbus(ctx, request)
+		atomic.AddUint64(&m.requests, 1)
+		switch {
+		case answer == nil:
+			atomic.AddUint64(&m.silent, 1)
+		case answer.IsException():
+			atomic.AddUint64(&m.exceptions, 1)
+		}
+		return answer, err
+	})
+}
+
+// Get count of served requests
+func (m *ModbusMetrics) GetRequests() uint64 {
+	return atomic.LoadUint64(&m.requests)
+}
+
+// Get count of requests answered by exception
+func (m *ModbusMetrics) GetExceptions() uint64 {
+	return atomic.LoadUint64(&m.exceptions)
+}
+
+// Get count of requests without answer
+func (m *ModbusMetrics) GetSilent() uint64 {
+	return atomic.LoadUint64(&m.silent)
+}
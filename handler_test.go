@@ -0,0 +1,133 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+// Middleware appending name to trace before and after next handler
+func traceMiddleware(trace *[]string, name string) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, request *ModbusPacket) (*ModbusPacket, error) {
+			*trace = append(*trace, name)
+			answer, err := next.ServeModbus(ctx, request)
+			*trace = append(*trace, name)
+			return answer, err
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	var trace []string
+	h := HandlerFunc(func(ctx context.Context, request *ModbusPacket) (*ModbusPacket, error) {
+		trace = append(trace, "h")
+		return buildAnswer(request), nil
+	})
+	req := buildRequest(0, ModbusTCP, 1, FcPresetSingleRegister, 0, 1)
+	Chain(h, traceMiddleware(&trace, "a"), traceMiddleware(&trace, "b")).ServeModbus(context.Background(), req)
+	target := []string{"a", "b", "h", "b", "a"}
+	if len(trace) != len(target) {
+		t.Fatal("Expected", target, "got", trace)
+	}
+	for i, v := range target {
+		if trace[i] != v {
+			t.Error("Expected", target, "got", trace)
+			break
+		}
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	h := Chain(HandlerFunc(func(ctx context.Context, request *ModbusPacket) (*ModbusPacket, error) {
+		return buildAnswer(request), nil
+	}), Authorization(ModbusRule{Role: "operator", FunctionCodes: []ModbusFunctionCode{FcReadHoldingRegisters}}))
+	req := buildRequest(0, ModbusTCP, 1, FcReadHoldingRegisters, 0, 1)
+	answ, err := h.ServeModbus(peerContext(nil, "operator"), req)
+	if err != nil || answ.IsException() {
+		t.Error("Expected answer, got", answ.GetException(), err)
+	}
+	answ, err = h.ServeModbus(peerContext(nil, "guest"), req)
+	if err == nil || answ.GetErrorCode() != ErrCantHandel {
+		t.Error("Expected exception", ErrCantHandel, "got", answ.GetException(), err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	metrics := &ModbusMetrics{}
+	h := Chain(HandlerFunc(func(ctx context.Context, request *ModbusPacket) (*ModbusPacket, error) {
+		return buildAnswer(request), nil
+	}), metrics.Middleware, RateLimit(0.001, 2))
+	req := buildRequest(0, ModbusTCP, 1, FcReadHoldingRegisters, 0, 1)
+	for i := 0; i < 3; i++ {
+		answ, _ := h.ServeModbus(context.Background(), req)
+		if busy := answ.GetErrorCode() == ErrBusy; busy != (i == 2) {
+			t.Error("For request", i, "expected busy", i == 2, "got", busy)
+		}
+	}
+	if metrics.GetRequests() != 3 || metrics.GetExceptions() != 1 || metrics.GetSilent() != 0 {
+		t.Error("Expected 3 1 0, got", metrics.GetRequests(), metrics.GetExceptions(), metrics.GetSilent())
+	}
+}
+
+func TestModbusServer_Handler(t *testing.T) {
+	md := new(ModbusData)
+	md.Init(10, 10, 10, 10)
+	srv := NewServer("127.0.0.1", "0", ModbusTCP, md)
+	// Wrap built-in handler, writes are forbidden
+	srv.Handler = HandlerFunc(func(ctx context.Context, request *ModbusPacket) (*ModbusPacket, error) {
+		if RemoteAddrFromContext(ctx) == nil {
+			return buildErrAnswer(request, ErrDeviceFailure), errors.New("Unknown client")
+		}
+		if request.GetFunctionCode() == FcPresetSingleRegister {
+			return buildErrAnswer(request, ErrCantHandel), errors.New("Read only")
+		}
+		return srv.RequestHadler(request)
+	})
+	metrics := &ModbusMetrics{}
+	// Counts building of middleware chain
+	builds := 0
+	srv.Use(Logging, metrics.Middleware, func(next Handler) Handler {
+		builds++
+		return next
+	})
+	if err := srv.Start(); err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer srv.Stop()
+	_, port, _ := net.SplitHostPort(srv.ln.Addr().String())
+	cl, err := NewClient(port, "127.0.0.1", ModbusTCP, 1)
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer cl.Close()
+
+	if _, err := cl.ReadHoldingRegisters(0, 1); err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	if err := cl.PresetSingleRegister(0, 1); !errors.Is(err, ErrCantHandel) {
+		t.Error("Expected", ErrCantHandel, "got", err)
+	}
+	if metrics.GetRequests() != 2 || metrics.GetExceptions() != 1 {
+		t.Error("Expected 2 1, got", metrics.GetRequests(), metrics.GetExceptions())
+	}
+	if builds != 1 {
+		t.Error("Expected chain built once, got", builds)
+	}
+}
+
+func TestRemoteAddrFromContext(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 502}
+	ctx := peerContext(addr, "operator")
+	if RemoteAddrFromContext(ctx) != addr || RoleFromContext(ctx) != "operator" {
+		t.Error("Expected", addr, "operator, got", RemoteAddrFromContext(ctx), RoleFromContext(ctx))
+	}
+	if RemoteAddrFromContext(context.Background()) != nil || RoleFromContext(context.Background()) != "" {
+		t.Error("Expected empty values, got", RemoteAddrFromContext(context.Background()))
+	}
+}
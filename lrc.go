@@ -0,0 +1,25 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+// Count LRC for passed as parameters data, LRC is two's complement of
+// the sum of all bytes
+func Lrc(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return -sum
+}
+
+// LrcCheck verifies data LRC
+func LrcCheck(data []byte, lrc byte) bool {
+	return Lrc(data) == lrc
+}
+
+// Appends LRC to passed data block
+func AppendLrc(data *[]byte) {
+	*data = append(*data, Lrc(*data))
+}
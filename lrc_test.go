@@ -0,0 +1,55 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"testing"
+)
+
+type testLrcPair struct {
+	data []byte
+	lrc  byte
+}
+
+var testsLrc = []testLrcPair{
+	{[]byte{0x1, 0x3, 0x0, 0x0, 0x0, 0xA}, 0xF2},
+	{[]byte{0x11, 0x3, 0x0, 0x6B, 0x0, 0x3}, 0x7E},
+	{nil, 0},
+}
+
+func TestLrc(t *testing.T) {
+	for _, pair := range testsLrc {
+		lrc := Lrc(pair.data)
+		if lrc != pair.lrc {
+			t.Error(
+				"For", pair.data,
+				"expected", pair.lrc,
+				"got", lrc,
+			)
+		}
+	}
+}
+
+func ExampleLrc() {
+	fmt.Printf("0x%X", Lrc([]byte{0x11, 0x3, 0x0, 0x6B, 0x0, 0x3}))
+	// Output: 0x7E
+}
+
+func TestLrcCheck(t *testing.T) {
+	data := []byte{0x1, 0x3, 0x0, 0x0, 0x0, 0xA}
+	r := LrcCheck(data, 0xF2)
+	if !r {
+		t.Error("Expected true, got ", r)
+	}
+}
+
+func TestAppendLrc(t *testing.T) {
+	data := []byte{0x11, 0x3, 0x0, 0x6B, 0x0, 0x3}
+	AppendLrc(&data)
+	if len(data) != 7 || data[6] != 0x7E {
+		t.Error("Expected 0x7E at the end, got ", data)
+	}
+}
@@ -15,15 +15,24 @@ var (
 	port       = flag.String("port", "502", "port number")
 	grpc_port  = flag.String("grpc_port", "9000", "port number")
 	host       = flag.String("host", "localhost", "hostname or host ip")
-	mbprotocol = flag.String("mbprotocol", "ModbusRTUviaTCP", "type of modbus protocol: ModbusTCP or ModbusRTUviaTCP")
+	mbprotocol = flag.String("mbprotocol", "ModbusRTUviaTCP", "type of modbus protocol: ModbusTCP, ModbusRTUviaTCP or ModbusASCII")
 )
 
 func main() {
 	flag.Parse()
 	fmt.Println("Modbus client app!")
 
-	cl, err := modbus.NewClient(*port, *host,
-		modbus.StringToModbusTypeProtocol(*mbprotocol), 1)
+	var (
+		cl  *modbus.ModbusClient
+		err error
+	)
+	if *protocol == "udp" {
+		cl, err = modbus.NewUDPClient(*port, *host,
+			modbus.StringToModbusTypeProtocol(*mbprotocol), 1)
+	} else {
+		cl, err = modbus.NewClient(*port, *host,
+			modbus.StringToModbusTypeProtocol(*mbprotocol), 1)
+	}
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -15,11 +15,12 @@ import (
 )
 
 var (
+	protocol            = flag.String("protocol", "tcp", "type of protocol, tcp/udp")
 	port                = flag.String("port", "502", "port number")
 	host                = flag.String("host", "localhost", "hostname or host ip")
 	rest_port           = flag.String("rest_port", "8000", "port number")
 	grpc_port           = flag.String("grpc_port", "9000", "port number")
-	mbprotocol          = flag.String("mbprotocol", "ModbusRTUviaTCP", "type of modbus protocol: ModbusTCP or ModbusRTUviaTCP")
+	mbprotocol          = flag.String("mbprotocol", "ModbusRTUviaTCP", "type of modbus protocol: ModbusTCP, ModbusRTUviaTCP or ModbusASCII")
 	coils_cnt           = flag.Int("coils_cnt", 65535, "coils counter")
 	discrete_inputs_cnt = flag.Int("discrete_inputs_cnt", 65535, "discrete inputs counter")
 	holding_reg_cnt     = flag.Int("holding_reg_cnt", 65535, "holding register counter")
@@ -37,8 +38,14 @@ func main() {
 	md.PresetMultipleRegisters(0, []uint16{0x01, 0x02, 0x03, 0x04, 0x05}...)
 	md.ForceMultipleCoils(0, []bool{true, false, false, true, true}...)
 
-	srv := modbus.NewServer(*host, *port,
-		modbus.StringToModbusTypeProtocol(*mbprotocol), md)
+	var srv *modbus.ModbusServer
+	if *protocol == "udp" {
+		srv = modbus.NewUDPServer(*host, *port,
+			modbus.StringToModbusTypeProtocol(*mbprotocol), md)
+	} else {
+		srv = modbus.NewServer(*host, *port,
+			modbus.StringToModbusTypeProtocol(*mbprotocol), md)
+	}
 
 	rest := modbusrest.NewRest(*host, *rest_port, md)
 
@@ -102,7 +102,7 @@ func (s *ModbusService) ForceMultipleCoils(ctx context.Context, req *ModbusWrite
 	return s.ReadCoilStatus(ctx, &ModbusRequest{Addr: req.Addr, Cnt: int32(len(req.Data))})
 }
 
-func NewgRPCService(host, port string, md *ModbusData) *ModbusService {
+func NewgRPCService(host, port string, md ModbusDataStore) *ModbusService {
 	srv := new(ModbusService)
 	srv.Data = md
 	srv.Host = host
@@ -173,7 +173,7 @@ func (rest *ModbusRest) hndlInputReg(w http.ResponseWriter, r *http.Request) {
 }
 
 // Create new Rest-server for Modbus Data
-func NewRest(host, port string, md *ModbusData) *ModbusRest {
+func NewRest(host, port string, md ModbusDataStore) *ModbusRest {
 	rest := new(ModbusRest)
 	rest.Host = host
 	rest.Port = port
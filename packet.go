@@ -8,6 +8,7 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"strings"
 )
 
 // ModbusPacket implements packet interface
@@ -26,9 +27,9 @@ func (mp *ModbusPacket) Init(typeProtocol ModbusTypeProtocol) {
 	mp.aPDU = mp.PDU[typeProtocol.Offset():]
 }
 
-// Get PDU length
+// Get PDU length, for ModbusTCP it includes MBAP header
 func (mp *ModbusPacket) GetPDULength() int {
-	return mp.Length + mp.TypeProtocol.Offset()
+	return mp.Length
 }
 
 // Get device address field from packet
@@ -56,6 +57,21 @@ func (mp *ModbusPacket) GetErrorCode() ModbusErrors {
 	return ModbusErrors(mp.aPDU[2])
 }
 
+// Checks that packet is exception answer
+func (mp *ModbusPacket) IsException() bool {
+	return byte(mp.GetFunctionCode())&0x80 != 0
+}
+
+// Get exception error from answer packet, nil if answer is not exception
+func (mp *ModbusPacket) GetException() error {
+	if !mp.IsException() {
+		return nil
+	}
+	return &ExceptionError{
+		FunctionCode:  ModbusFunctionCode(byte(mp.GetFunctionCode()) &^ 0x80),
+		ExceptionCode: mp.GetErrorCode()}
+}
+
 // Get fucntion parameters from request packet
 func (mp *ModbusPacket) GetFunctionParameters() (uint16, uint16) {
 	if mp.isAnswer {
@@ -70,15 +86,22 @@ func (mp *ModbusPacket) SetFunctionParameters(par1, par2 uint16) {
 	binary.BigEndian.PutUint16(mp.aPDU[4:6], par2)
 }
 
-// Get data len byte + data bytes from Modbus packet
+// Get data len byte + data bytes from Modbus packet. Data is cut by the end
+// of packet, so length of data differs from len byte in malformed packet.
 func (mp *ModbusPacket) GetData() (byte, []byte) {
+	pos := 7
 	if mp.isAnswer {
-		cnt := mp.aPDU[2]
-		return cnt, mp.aPDU[3 : cnt+3]
-	} else {
-		cnt := mp.aPDU[6]
-		return cnt, mp.aPDU[7 : cnt+7]
+		pos = 3
+	}
+	end := mp.Length - mp.TypeProtocol.Offset() - mp.checksumSize()
+	if end < pos {
+		return 0, nil
+	}
+	cnt := mp.aPDU[pos-1]
+	if pos+int(cnt) < end {
+		end = pos + int(cnt)
 	}
+	return cnt, mp.aPDU[pos:end]
 }
 
 // Set data with data len byte to Modbus packet
@@ -92,18 +115,18 @@ func (mp *ModbusPacket) SetData(cnt byte, data []byte) {
 	}
 }
 
-// Get CRC field from packet
+// Get CRC field from packet, CRC is transmitted low byte first
 func (mp *ModbusPacket) GetCrc() uint16 {
-	if mp.Length == 0 || mp.TypeProtocol == ModbusTCP {
+	if mp.Length < 2 || mp.TypeProtocol != ModbusRTUviaTCP {
 		return 0
 	}
-	return binary.BigEndian.Uint16(mp.aPDU[mp.Length-2 : mp.Length])
+	return binary.LittleEndian.Uint16(mp.aPDU[mp.Length-2 : mp.Length])
 }
 
 // Caculcate and adds crc to packet
 // This function change ModbusPacket length
 func (mp *ModbusPacket) SetCrc() {
-	if mp.TypeProtocol == ModbusTCP {
+	if mp.TypeProtocol != ModbusRTUviaTCP {
 		return
 	}
 	bs := make([]byte, 2)
@@ -114,15 +137,100 @@ func (mp *ModbusPacket) SetCrc() {
 
 // Recalculate and check CRC of packet
 func (mp *ModbusPacket) IsCrcGood() bool {
-	if mp.Length == 0 || mp.GetCrc() == 0 {
+	if mp.Length < 3 || mp.TypeProtocol != ModbusRTUviaTCP {
 		return false
 	}
 	return Crc16Check(mp.aPDU[:mp.Length-2], mp.GetCrc())
 }
 
+// Get LRC field from ModbusASCII packet
+func (mp *ModbusPacket) GetLrc() byte {
+	if mp.Length == 0 || mp.TypeProtocol != ModbusASCII {
+		return 0
+	}
+	return mp.aPDU[mp.Length-1]
+}
+
+// Caculcate and adds LRC to ModbusASCII packet
+// This function change ModbusPacket length
+func (mp *ModbusPacket) SetLrc() {
+	if mp.TypeProtocol != ModbusASCII {
+		return
+	}
+	mp.aPDU[mp.Length] = Lrc(mp.aPDU[:mp.Length])
+	mp.Length++
+}
+
+// Recalculate and check LRC of ModbusASCII packet
+func (mp *ModbusPacket) IsLrcGood() bool {
+	if mp.Length == 0 || mp.TypeProtocol != ModbusASCII {
+		return false
+	}
+	return LrcCheck(mp.aPDU[:mp.Length-1], mp.GetLrc())
+}
+
+// Check checksum of serial line protocols, ModbusTCP packet has no
+// checksum and is always good
+func (mp *ModbusPacket) IsChecksumGood() bool {
+	switch mp.TypeProtocol {
+	case ModbusRTUviaTCP:
+		// CRC of data with appended CRC is zero
+		return mp.Length > 2 && Crc16(mp.aPDU[:mp.Length]) == 0
+	case ModbusASCII:
+		return mp.IsLrcGood()
+	default:
+		return true
+	}
+}
+
+// Caculcate and adds checksum of serial line protocols to packet
+// This function change ModbusPacket length
+func (mp *ModbusPacket) setChecksum() {
+	switch mp.TypeProtocol {
+	case ModbusRTUviaTCP:
+		mp.SetCrc()
+	case ModbusASCII:
+		mp.SetLrc()
+	}
+}
+
+// Size of checksum of serial line protocols
+func (mp *ModbusPacket) checksumSize() int {
+	switch mp.TypeProtocol {
+	case ModbusRTUviaTCP:
+		return 2
+	case ModbusASCII:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Get raw PDU from packet: function code and data without address and
+// checksum
+func (mp *ModbusPacket) GetRawPDU() []byte {
+	end := mp.Length - mp.TypeProtocol.Offset() - mp.checksumSize()
+	if end < 2 {
+		return nil
+	}
+	return mp.aPDU[1:end]
+}
+
+// Get frame for sending to connection. ModbusASCII frame is hex encoded
+// and enclosed by start colon and end CR LF.
+func (mp *ModbusPacket) GetFrame() []byte {
+	if mp.TypeProtocol != ModbusASCII {
+		return mp.PDU[:mp.GetPDULength()]
+	}
+	frame := make([]byte, 0, 2*mp.Length+3)
+	frame = append(frame, ':')
+	frame = append(frame, strings.ToUpper(hex.EncodeToString(mp.PDU[:mp.Length]))...)
+	return append(frame, '\r', '\n')
+}
+
 // Get Transaction Id for ModbusTCP
 func (mp *ModbusPacket) GetTransactionId() uint16 {
-	if mp.TypeProtocol == ModbusRTUviaTCP {
+	if mp.TypeProtocol != ModbusTCP {
 		return 0
 	}
 	return binary.BigEndian.Uint16(mp.PDU[0:3])
@@ -130,7 +238,7 @@ func (mp *ModbusPacket) GetTransactionId() uint16 {
 
 // Set Transaction Id for ModbusTCP
 func (mp *ModbusPacket) SetTransactionId(id uint16) {
-	if mp.TypeProtocol == ModbusRTUviaTCP {
+	if mp.TypeProtocol != ModbusTCP {
 		return
 	}
 	binary.BigEndian.PutUint16(mp.PDU[0:3], id)
@@ -144,23 +252,29 @@ func (mp *ModbusPacket) Dump(msg ...string) {
 	if mp.TypeProtocol == ModbusTCP {
 		fmt.Printf("Packet Transaction Id: \t\t%d\n", mp.GetTransactionId())
 	}
-	fmt.Printf("Packet length: \t\t\t%d\n", mp.Length-mp.TypeProtocol.Offset())
+	length := mp.Length - mp.TypeProtocol.Offset()
+	if length < 0 {
+		length = 0
+	}
+	fmt.Printf("Packet length: \t\t\t%d\n", length)
 	fmt.Printf("Slave addr: \t\t\t%x\n", mp.GetDevID())
 	fmt.Printf("Code function: \t\t\t%s(0x%x)\n", mp.GetFunctionCode(), byte(mp.GetFunctionCode()))
 	fmt.Println("Packet data:")
-	fmt.Println(hex.Dump(mp.aPDU[0 : mp.Length-mp.TypeProtocol.Offset()]))
+	fmt.Println(hex.Dump(mp.aPDU[:length]))
 	if mp.TypeProtocol == ModbusRTUviaTCP {
 		bs := make([]byte, 2)
 		binary.LittleEndian.PutUint16(bs, mp.GetCrc())
 		fmt.Printf("Modbus CRC16: \t\t\t%x %x\n\n", bs[0], bs[1])
 	}
+	if mp.TypeProtocol == ModbusASCII {
+		fmt.Printf("Modbus LRC: \t\t\t%x\n\n", mp.GetLrc())
+	}
 }
 
 func (mp *ModbusPacket) initLength() {
 	if mp.TypeProtocol == ModbusTCP {
 		mp.Length = 6
-	}
-	if mp.TypeProtocol == ModbusRTUviaTCP {
+	} else {
 		mp.Length = 0
 	}
 }
@@ -189,15 +303,34 @@ func (mp *ModbusPacket) buildPDU(devid byte, fc ModbusFunctionCode, par1, par2 u
 		mp.SetData(byte(len(data)), data)
 		mp.Length += len(data) + 1
 	}
-	// Set Crc
-	if mp.TypeProtocol == ModbusRTUviaTCP {
-		mp.SetCrc()
-	}
+	// Set Crc or Lrc
+	mp.setChecksum()
 	// Set Message Length
+	mp.setMBAPLength()
+}
+
+// Build ModbusPacket with raw data after function code
+func (mp *ModbusPacket) buildRawPDU(devid byte, fc ModbusFunctionCode, data []byte) {
+	mp.initLength()
+	// Set Device ID
+	mp.SetDevID(devid)
+	mp.Length++
+	// Set Function Code
+	mp.SetFunctionCode(fc)
+	mp.Length++
+	// Set data
+	mp.Length += copy(mp.aPDU[2:], data)
+	// Set Crc or Lrc
+	mp.setChecksum()
+	// Set Message Length
+	mp.setMBAPLength()
+}
+
+// Set length field of MBAP header for ModbusTCP
+func (mp *ModbusPacket) setMBAPLength() {
 	if mp.TypeProtocol == ModbusTCP {
 		binary.BigEndian.PutUint16(mp.PDU[4:6], uint16(mp.Length-mp.TypeProtocol.Offset()))
 	}
-
 }
 
 // Build ModbusPacket for errors
@@ -210,12 +343,12 @@ func (mp *ModbusPacket) buildErrPDU(devid byte, fc ModbusFunctionCode, errCode M
 	mp.SetFunctionCode(ModbusFunctionCode(byte(fc) | 0x80))
 	mp.Length++
 	// Set Error code
-	mp.aPDU[mp.Length+1] = byte(errCode)
+	mp.aPDU[mp.Length-mp.TypeProtocol.Offset()] = byte(errCode)
 	mp.Length++
-	// Set Crc
-	if mp.TypeProtocol == ModbusRTUviaTCP {
-		mp.SetCrc()
-	}
+	// Set Crc or Lrc
+	mp.setChecksum()
+	// Set Message Length
+	mp.setMBAPLength()
 }
 
 // Build error answer ModbusPacket for src packet
@@ -237,6 +370,21 @@ func buildAnswer(src *ModbusPacket, data ...byte) *ModbusPacket {
 	return answer
 }
 
+// Build answer for request of user-defined function with raw data after
+// function code
+func NewAnswer(request *ModbusPacket, data []byte) *ModbusPacket {
+	answer := &ModbusPacket{isAnswer: true}
+	answer.Init(request.TypeProtocol)
+	answer.buildRawPDU(request.GetDevID(), request.GetFunctionCode(), data)
+	answer.SetTransactionId(request.GetTransactionId())
+	return answer
+}
+
+// Build exception answer for request
+func NewErrAnswer(request *ModbusPacket, errCode ModbusErrors) *ModbusPacket {
+	return buildErrAnswer(request, errCode)
+}
+
 // Build request
 func buildRequest(transactionId uint16, typeProtocol ModbusTypeProtocol, devid byte, fc ModbusFunctionCode,
 	par1, par2 uint16, data ...byte) *ModbusPacket {
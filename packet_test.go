@@ -15,7 +15,9 @@ type testCrc16Checkpair struct {
 }
 
 var testsCrc16Check = []testCrc16Checkpair{
-	{ModbusRTUviaTCP, []byte{0x1, 0x3, 0x0, 0x0, 0x0, 0xA, 0xCD, 0xC5}, true},
+	{ModbusRTUviaTCP, []byte{0x1, 0x3, 0x0, 0x0, 0x0, 0xA, 0xC5, 0xCD}, true},
+	{ModbusRTUviaTCP, []byte{0x1, 0x3, 0x0, 0x0, 0x0, 0xA, 0xCD, 0xC5}, false},
+	{ModbusTCP, []byte{0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x1, 0x3, 0x0, 0x0, 0x0, 0xA}, false},
 }
 
 func TestModbusPacket_Crc16Check(t *testing.T) {
@@ -79,7 +81,7 @@ func TestModbusPacket_GetFC(t *testing.T) {
 }
 
 func TestModbusPacket_GetCrc(t *testing.T) {
-	test_data := []byte{0x1, 0x3, 0x0, 0x0, 0x0, 0xA, 0xCD, 0xC5}
+	test_data := []byte{0x1, 0x3, 0x0, 0x0, 0x0, 0xA, 0xC5, 0xCD}
 	mp := &ModbusPacket{}
 	mp.Init(ModbusRTUviaTCP)
 	copy(mp.PDU, test_data)
@@ -110,6 +112,8 @@ type testbuildPacketpair struct {
 
 var testsbuildPacket = []testbuildPacketpair{
 	{true, ModbusRTUviaTCP, 1, FcReadHoldingRegisters, 0x0, 0xA, []byte{0x1, 0x3, 0x0, 0x0, 0x0, 0xA, 0xC5, 0xCD}},
+	{true, ModbusTCP, 1, FcReadHoldingRegisters, 0x0, 0xA, []byte{0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x1, 0x3, 0x0, 0x0, 0x0, 0xA}},
+	{true, ModbusASCII, 1, FcReadHoldingRegisters, 0x0, 0xA, []byte{0x1, 0x3, 0x0, 0x0, 0x0, 0xA, 0xF2}},
 }
 
 func TestModbusPacket_buildPDU(t *testing.T) {
@@ -117,6 +121,10 @@ func TestModbusPacket_buildPDU(t *testing.T) {
 		mp := &ModbusPacket{}
 		mp.Init(pair.TypeProtocol)
 		mp.buildPDU(pair.dev_id, pair.fc, pair.par1, pair.par2)
+		if mp.GetPDULength() != len(pair.PDU) {
+			t.Error("For", pair, "expected length", len(pair.PDU), "got", mp.GetPDULength())
+			continue
+		}
 		for i, v := range mp.PDU[:mp.GetPDULength()] {
 			if v != pair.PDU[i] {
 				t.Error(
@@ -128,3 +136,82 @@ func TestModbusPacket_buildPDU(t *testing.T) {
 		}
 	}
 }
+
+func TestModbusPacket_GetFrame(t *testing.T) {
+	mp := buildRequest(0, ModbusASCII, 0x11, FcReadHoldingRegisters, 0x6B, 0x3)
+	target := ":1103006B00037E\r\n"
+	if string(mp.GetFrame()) != target {
+		t.Error("Expected", target, "got", string(mp.GetFrame()))
+	}
+	if !mp.IsLrcGood() {
+		t.Error("Expected true, got false")
+	}
+	mp = buildRequest(0, ModbusRTUviaTCP, 0x1, FcReadHoldingRegisters, 0x0, 0xA)
+	if len(mp.GetFrame()) != mp.GetPDULength() {
+		t.Error("Expected", mp.GetPDULength(), "got", len(mp.GetFrame()))
+	}
+}
+
+type testbuildErrAnswerpair struct {
+	TypeProtocol ModbusTypeProtocol
+	PDU          []byte
+}
+
+var testsbuildErrAnswer = []testbuildErrAnswerpair{
+	{ModbusRTUviaTCP, []byte{0x1, 0x83, 0x2, 0xC0, 0xF1}},
+	{ModbusTCP, []byte{0x0, 0x7, 0x0, 0x0, 0x0, 0x3, 0x1, 0x83, 0x2}},
+	{ModbusASCII, []byte{0x1, 0x83, 0x2, 0x7A}},
+}
+
+func TestModbusPacket_buildErrAnswer(t *testing.T) {
+	for _, pair := range testsbuildErrAnswer {
+		req := buildRequest(7, pair.TypeProtocol, 1, FcReadHoldingRegisters, 0, 1)
+		mp := buildErrAnswer(req, ErrOutside)
+		if mp.GetPDULength() != len(pair.PDU) {
+			t.Error("For", pair.TypeProtocol, "expected length", len(pair.PDU), "got", mp.GetPDULength())
+			continue
+		}
+		for i, v := range mp.PDU[:mp.GetPDULength()] {
+			if v != pair.PDU[i] {
+				t.Error("For", pair.TypeProtocol, "expected", pair.PDU[i], "got", v)
+			}
+		}
+		exc, ok := mp.GetException().(*ExceptionError)
+		if !ok || exc.FunctionCode != FcReadHoldingRegisters || exc.ExceptionCode != ErrOutside {
+			t.Error("For", pair.TypeProtocol, "expected exception, got", mp.GetException())
+		}
+	}
+}
+
+func TestModbusPacket_IsChecksumGood(t *testing.T) {
+	for _, mbprotocol := range []ModbusTypeProtocol{ModbusTCP, ModbusRTUviaTCP, ModbusASCII} {
+		mp := buildRequest(0, mbprotocol, 1, FcReadHoldingRegisters, 0x0, 0xA)
+		if !mp.IsChecksumGood() {
+			t.Error("For", mbprotocol, "expected true, got false")
+		}
+		mp.aPDU[1] ^= 0xFF
+		if mp.IsChecksumGood() != (mbprotocol == ModbusTCP) {
+			t.Error("For", mbprotocol, "expected", mbprotocol == ModbusTCP, "got", mp.IsChecksumGood())
+		}
+	}
+}
+
+func TestModbusPacket_GetData(t *testing.T) {
+	mp := buildRequest(0, ModbusRTUviaTCP, 1, FcPresetMultipleRegisters, 0x0, 0x1, 0x0, 0x7)
+	if cnt, data := mp.GetData(); cnt != 2 || len(data) != 2 || data[1] != 7 {
+		t.Error("Expected 2 [0 7], got", cnt, data)
+	}
+	// Byte count is greater than packet
+	mp.aPDU[6] = 0xFF
+	if cnt, data := mp.GetData(); cnt != 0xFF || len(data) != 2 {
+		t.Error("Expected 255 and 2 bytes of data, got", cnt, data)
+	}
+	mp.Length = 3
+	if cnt, data := mp.GetData(); cnt != 0 || data != nil {
+		t.Error("Expected 0 [], got", cnt, data)
+	}
+	mp.Length = 1
+	if crc := mp.GetCrc(); crc != 0 {
+		t.Error("Expected 0, got", crc)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Result of asynchronous request
+type ModbusResult struct {
+	Answer *ModbusPacket
+	Err    error
+}
+
+// Pipeline keeps several outstanding ModbusTCP requests on connection and
+// dispatches answers by transaction ID
+type pipeline struct {
+	mc      *ModbusClient
+	conn    net.Conn
+	window  chan struct{}              // Occupied slots of in-flight window
+	wmu     sync.Mutex                 // Serializes writing of requests
+	mu      sync.Mutex                 // Guards pending and err
+	pending map[uint16]*pendingRequest // Requests waiting answer
+	err     error                      // Error of reading, pipeline is stopped
+}
+
+// Request waiting answer in pipeline
+type pendingRequest struct {
+	request *ModbusPacket
+	result  chan ModbusResult
+	done    chan struct{}
+}
+
+// Start pipelining of requests on ModbusTCP connection, up to window
+// requests are kept on the wire. After start requests of client are sent
+// without waiting answers to previous ones. Pipeline must be started before
+// concurrent use of client. Broken connection is not redialed.
+func (mc *ModbusClient) StartPipeline(window int) error {
+	if mc.TypeProtocol != ModbusTCP || mc.isDatagram() {
+		return errors.New("Pipelining is supported only for ModbusTCP stream connection")
+	}
+	if window < 1 {
+		return errors.New("Window of pipeline must be positive")
+	}
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.pipe != nil {
+		return errors.New("Pipeline is already started")
+	}
+	if err := mc.checkConn(); err != nil {
+		return err
+	}
+	mc.pipe = &pipeline{
+		mc:      mc,
+		conn:    mc.Conn,
+		window:  make(chan struct{}, window),
+		pending: make(map[uint16]*pendingRequest)}
+	// Answers are waited by pipeline timers
+	mc.Conn.SetReadDeadline(time.Time{})
+	go mc.pipe.readAnswers(newFrameReader(mc.Conn, ModbusTCP, true))
+	return nil
+}
+
+// Send Request to Slave device (Server), answer is delivered to returned
+// channel. Call blocks while in-flight window of pipeline is full. Without
+// pipeline request is sent in background. Retry policy is not applied.
+func (mc *ModbusClient) SendRequestAsync(ctx context.Context, mp *ModbusPacket) <-chan ModbusResult {
+	result := make(chan ModbusResult, 1)
+	if mc.pipe == nil {
+		go func() {
+			answer, err := mc.SendRequestContext(ctx, mp)
+			result <- ModbusResult{answer, err}
+		}()
+		return result
+	}
+	mc.pipe.send(ctx, mp, result)
+	return result
+}
+
+// Send request to pipeline and wait answer
+func (p *pipeline) sendRequest(ctx context.Context, mp *ModbusPacket) (*ModbusPacket, error) {
+	result := make(chan ModbusResult, 1)
+	p.send(ctx, mp, result)
+	r := <-result
+	return r.Answer, r.Err
+}
+
+// Write request to connection, result is delivered when answer is received,
+// response timeout is expired or ctx is done
+func (p *pipeline) send(ctx context.Context, mp *ModbusPacket, result chan ModbusResult) {
+	select {
+	case p.window <- struct{}{}:
+	case <-ctx.Done():
+		result <- ModbusResult{nil, ctx.Err()}
+		return
+	}
+	tid := mp.GetTransactionId()
+	req := &pendingRequest{request: mp, result: result, done: make(chan struct{})}
+	p.mu.Lock()
+	err := p.err
+	if _, ok := p.pending[tid]; ok && err == nil {
+		err = errors.New("Transaction ID is already in flight")
+	}
+	if err != nil {
+		p.mu.Unlock()
+		<-p.window
+		result <- ModbusResult{nil, err}
+		return
+	}
+	p.pending[tid] = req
+	p.mu.Unlock()
+
+	log.Println("Send request to", p.mc)
+	p.wmu.Lock()
+	p.conn.SetWriteDeadline(p.mc.deadline(ctx))
+	_, err = p.conn.Write(mp.GetFrame())
+	p.wmu.Unlock()
+	if err != nil {
+		log.Println("Error connect:", err.Error())
+		p.complete(tid, nil, contextError(ctx, err))
+		return
+	}
+	go p.watch(ctx, tid, req)
+}
+
+// Complete request by timeout or done ctx
+func (p *pipeline) watch(ctx context.Context, tid uint16, req *pendingRequest) {
+	var timeout <-chan time.Time
+	if p.mc.Timeout > 0 {
+		t := time.NewTimer(p.mc.Timeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case <-req.done:
+	case <-ctx.Done():
+		p.complete(tid, nil, ctx.Err())
+	case <-timeout:
+		p.complete(tid, nil, os.ErrDeadlineExceeded)
+	}
+}
+
+// Deliver result to request waiting answer, false if there is no such
+// request
+func (p *pipeline) complete(tid uint16, answer *ModbusPacket, err error) bool {
+	p.mu.Lock()
+	req, ok := p.pending[tid]
+	delete(p.pending, tid)
+	p.mu.Unlock()
+	if !ok {
+		return false
+	}
+	if answer != nil {
+		if err = checkAnswer(req.request, answer); err != nil {
+			answer = nil
+		}
+	}
+	req.result <- ModbusResult{answer, err}
+	close(req.done)
+	<-p.window
+	return true
+}
+
+// Read answers and dispatch them by transaction ID. Error of reading stops
+// pipeline and fails all waiting requests.
+func (p *pipeline) readAnswers(fr frameReader) {
+	for {
+		answer := &ModbusPacket{isAnswer: true}
+		answer.Init(ModbusTCP)
+		err := fr.ReadFrame(answer)
+		if err != nil {
+			log.Println("Error reading:", err.Error())
+			p.mu.Lock()
+			p.err = err
+			tids := make([]uint16, 0, len(p.pending))
+			for tid := range p.pending {
+				tids = append(tids, tid)
+			}
+			p.mu.Unlock()
+			for _, tid := range tids {
+				p.complete(tid, nil, err)
+			}
+			return
+		}
+		if !p.complete(answer.GetTransactionId(), answer, nil) {
+			log.Println("Drop answer for transaction", answer.GetTransactionId())
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Start server which reads cnt requests and answers them in reverse
+// order, register value in answer is equal to address in request
+func startReverseServer(t *testing.T, cnt int) (net.Listener, *ModbusClient) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fr := newFrameReader(conn, ModbusTCP, false)
+		requests := make([]*ModbusPacket, 0, cnt)
+		for len(requests) < cnt {
+			request := &ModbusPacket{}
+			request.Init(ModbusTCP)
+			if fr.ReadFrame(request) != nil {
+				return
+			}
+			requests = append(requests, request)
+		}
+		for i := len(requests) - 1; i >= 0; i-- {
+			addr, _ := requests[i].GetFunctionParameters()
+			conn.Write(buildAnswer(requests[i], byte(addr>>8), byte(addr)).GetFrame())
+		}
+		conn.Read(make([]byte, 1))
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	cl, err := NewClient(port, "127.0.0.1", ModbusTCP, 1)
+	if err != nil {
+		ln.Close()
+		t.Fatal("Expected nil, got", err)
+	}
+	return ln, cl
+}
+
+func TestModbusClient_SendRequestAsync(t *testing.T) {
+	ln, cl := startReverseServer(t, 4)
+	defer ln.Close()
+	defer cl.Close()
+	if err := cl.StartPipeline(4); err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+
+	results := make([]<-chan ModbusResult, 4)
+	for i := range results {
+		request := buildRequest(cl.GetTransactionId(), ModbusTCP, 1, FcReadHoldingRegisters, uint16(i), 1)
+		results[i] = cl.SendRequestAsync(context.Background(), request)
+	}
+	for i, result := range results {
+		r := <-result
+		if r.Err != nil {
+			t.Error("Expected nil, got", r.Err)
+			continue
+		}
+		_, data := r.Answer.GetData()
+		if len(data) != 2 || data[1] != byte(i) {
+			t.Error("Expected", i, "got", data)
+		}
+	}
+}
+
+func TestModbusClient_Pipeline(t *testing.T) {
+	ln, cl := startReverseServer(t, 3)
+	defer ln.Close()
+	defer cl.Close()
+	if err := cl.StartPipeline(3); err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	if err := cl.StartPipeline(3); err == nil {
+		t.Error("Expected error, got nil")
+	}
+
+	// Synchronous requests are pipelined too
+	var wg sync.WaitGroup
+	for i := uint16(1); i <= 3; i++ {
+		wg.Add(1)
+		go func(addr uint16) {
+			defer wg.Done()
+			res, err := cl.ReadHoldingRegisters(addr, 1)
+			if err != nil || len(res) != 1 || res[0] != addr {
+				t.Error("Expected", addr, "got", res, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestModbusClient_PipelineWindow(t *testing.T) {
+	ln, cl := startSilentServer(t)
+	defer ln.Close()
+	defer cl.Close()
+	cl.Timeout = 0
+	if err := cl.StartPipeline(1); err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	request := buildRequest(cl.GetTransactionId(), ModbusTCP, 1, FcReadHoldingRegisters, 0, 1)
+	first := cl.SendRequestAsync(ctx, request)
+
+	// Window is full, request is not sent until ctx is done
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel2()
+	request = buildRequest(cl.GetTransactionId(), ModbusTCP, 1, FcReadHoldingRegisters, 0, 1)
+	if r := <-cl.SendRequestAsync(ctx2, request); r.Err != context.DeadlineExceeded {
+		t.Error("Expected", context.DeadlineExceeded, "got", r.Err)
+	}
+
+	cancel()
+	if r := <-first; r.Err != context.Canceled {
+		t.Error("Expected", context.Canceled, "got", r.Err)
+	}
+
+	// Closing of connection fails waiting requests
+	request = buildRequest(cl.GetTransactionId(), ModbusTCP, 1, FcReadHoldingRegisters, 0, 1)
+	result := cl.SendRequestAsync(context.Background(), request)
+	cl.Close()
+	if r := <-result; r.Err == nil {
+		t.Error("Expected error, got nil")
+	}
+}
+
+func TestModbusClient_PipelineTimeout(t *testing.T) {
+	ln, cl := startSilentServer(t)
+	defer ln.Close()
+	defer cl.Close()
+	cl.Timeout = 50 * time.Millisecond
+	if err := cl.StartPipeline(2); err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	if _, err := cl.ReadHoldingRegisters(0, 1); !isTimeout(err) {
+		t.Error("Expected timeout, got", err)
+	}
+}
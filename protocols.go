@@ -7,26 +7,30 @@ package modbus
 // Type of Modbus Protocol:
 // - ModbusTCP
 // - ModbusRTUviaTCP
+// - ModbusASCII
 type ModbusTypeProtocol int
 
 const (
 	ModbusTCP       ModbusTypeProtocol = 0
 	ModbusRTUviaTCP ModbusTypeProtocol = 1
+	ModbusASCII     ModbusTypeProtocol = 2
 	ModbusUnknown                      = -1
 )
 
 const (
 	ModbusRTUviaTCPMaxSize int = 256
 	ModbusTCPMaxSize       int = 260
+	ModbusASCIIMaxSize     int = 255 // Decoded frame: address, PDU and LRC
 )
 
 // Get MaxSize of packet for this protocol
 func (p ModbusTypeProtocol) MaxSize() int {
 	names := []int{
 		ModbusTCPMaxSize,
-		ModbusRTUviaTCPMaxSize}
+		ModbusRTUviaTCPMaxSize,
+		ModbusASCIIMaxSize}
 
-	if p < ModbusTCP || p > ModbusRTUviaTCP {
+	if p < ModbusTCP || p > ModbusASCII {
 		return 0
 	}
 
@@ -45,9 +49,10 @@ func (p ModbusTypeProtocol) Offset() int {
 func (p ModbusTypeProtocol) String() string {
 	names := []string{
 		"ModbusTCP",
-		"ModbusRTUviaTCP"}
+		"ModbusRTUviaTCP",
+		"ModbusASCII"}
 
-	if p < ModbusTCP || p > ModbusRTUviaTCP {
+	if p < ModbusTCP || p > ModbusASCII {
 		return "Unknown"
 	}
 
@@ -61,6 +66,8 @@ func StringToModbusTypeProtocol(name string) ModbusTypeProtocol {
 		return ModbusTCP
 	case "ModbusRTUviaTCP":
 		return ModbusRTUviaTCP
+	case "ModbusASCII":
+		return ModbusASCII
 	default:
 		return ModbusUnknown
 	}
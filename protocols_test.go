@@ -16,6 +16,7 @@ type testTypeProtocolpair struct {
 var testsTypeProtocol = []testTypeProtocolpair{
 	{"ModbusRTUviaTCP", ModbusRTUviaTCP},
 	{"ModbusTCP", ModbusTCP},
+	{"ModbusASCII", ModbusASCII},
 }
 
 func TestStringToModbusTypeProtocol(t *testing.T) {
@@ -42,6 +43,7 @@ type testModbusTypeProtocolpair struct {
 var testsModbusTypeProtocol = []testModbusTypeProtocolpair{
 	{ModbusTCP, "ModbusTCP", 260, 6},
 	{ModbusRTUviaTCP, "ModbusRTUviaTCP", 256, 0},
+	{ModbusASCII, "ModbusASCII", 255, 0},
 }
 
 func TestModbusTypeProtocol_String(t *testing.T) {
@@ -0,0 +1,189 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// State of client connection
+type ModbusConnState int
+
+const (
+	ConnStateConnected    ModbusConnState = 0
+	ConnStateDisconnected ModbusConnState = 1
+	ConnStateConnecting   ModbusConnState = 2
+)
+
+// Get the name of this state
+func (s ModbusConnState) String() string {
+	switch s {
+	case ConnStateConnected:
+		return "Connected"
+	case ConnStateDisconnected:
+		return "Disconnected"
+	case ConnStateConnecting:
+		return "Connecting"
+	default:
+		return "Unknown"
+	}
+}
+
+// Errors of client connection
+var (
+	ErrClientClosed = errors.New("Client is closed")
+	ErrNotConnected = errors.New("Client is not connected")
+)
+
+// Hook called when state of client connection is changed, err is the
+// cause of disconnection
+type ConnStateHook func(state ModbusConnState, err error)
+
+// ReconnectPolicy describes how ModbusClient re-establishes broken
+// connection. Connection is redialed on the next request after I/O error.
+// If first dial in constructor fails, returned client is redialed on the
+// next request too.
+type ReconnectPolicy struct {
+	MaxAttempts    int           // Connection attempts per request, 0 is unlimited
+	InitialBackoff time.Duration // Delay before second attempt
+	MaxBackoff     time.Duration // Limit of delay between attempts
+	Multiplier     float64       // Growth of delay after each attempt
+	Jitter         float64       // Random deviation of delay, part of delay from 0 to 1
+	ConnectTimeout time.Duration // Timeout of one attempt, 0 is unlimited
+}
+
+// Default reconnect policy
+var DefaultReconnectPolicy = ReconnectPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+	ConnectTimeout: 5 * time.Second,
+}
+
+// Delay before attempt, attempts are counted from 0
+func (p *ReconnectPolicy) backoff(attempt int) time.Duration {
+	if attempt == 0 {
+		return 0
+	}
+	d := float64(p.InitialBackoff)
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || d < float64(p.MaxBackoff)); i++ {
+		d *= p.Multiplier
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	d += d * p.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(d)
+}
+
+// Function establishing connection of client
+type dialFunc func(ctx context.Context) (net.Conn, error)
+
+// Establish connection, attempt is limited by connect timeout of policy
+func (mc *ModbusClient) connect(ctx context.Context) error {
+	if mc.Reconnect != nil && mc.Reconnect.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, mc.Reconnect.ConnectTimeout)
+		defer cancel()
+	}
+	mc.setState(ConnStateConnecting, nil)
+	conn, err := mc.dial(ctx)
+	if err != nil {
+		mc.connMu.Lock()
+		mc.broken = true
+		mc.connMu.Unlock()
+		mc.setState(ConnStateDisconnected, err)
+		return err
+	}
+	mc.connMu.Lock()
+	if mc.closed {
+		mc.connMu.Unlock()
+		conn.Close()
+		return ErrClientClosed
+	}
+	mc.Conn = conn
+	mc.fr = nil
+	mc.flush = false
+	mc.broken = false
+	mc.connMu.Unlock()
+	mc.setState(ConnStateConnected, nil)
+	return nil
+}
+
+// Checks that client can send request: client isn't closed and has
+// connection
+func (mc *ModbusClient) checkConn() error {
+	mc.connMu.Lock()
+	defer mc.connMu.Unlock()
+	if mc.closed {
+		return ErrClientClosed
+	}
+	if mc.Conn == nil {
+		return ErrNotConnected
+	}
+	return nil
+}
+
+// Redial broken connection according to reconnect policy
+func (mc *ModbusClient) reconnect(ctx context.Context) error {
+	var err error
+	for attempt := 0; mc.Reconnect.MaxAttempts == 0 || attempt < mc.Reconnect.MaxAttempts; attempt++ {
+		if d := mc.Reconnect.backoff(attempt); d > 0 {
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		log.Println("Reconnect to", mc, "attempt", attempt+1)
+		if err = mc.connect(ctx); err == nil {
+			return nil
+		}
+		log.Println("Error connect:", err.Error())
+		if err == ErrClientClosed {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// Close connection broken by I/O error, it will be redialed on next request
+func (mc *ModbusClient) disconnect(err error) {
+	mc.connMu.Lock()
+	if mc.broken || mc.closed {
+		mc.connMu.Unlock()
+		return
+	}
+	mc.broken = true
+	if mc.Conn != nil {
+		mc.Conn.Close()
+	}
+	mc.connMu.Unlock()
+	mc.setState(ConnStateDisconnected, err)
+}
+
+// Notify application about connection state
+func (mc *ModbusClient) setState(state ModbusConnState, err error) {
+	if mc.OnStateChange != nil {
+		mc.OnStateChange(state, err)
+	}
+}
+
+// Checks that error is caused by broken connection, timeouts, bad
+// checksums, broken frames and canceled requests keep connection
+func isConnError(err error) bool {
+	return err != nil && !isTimeout(err) && err != ErrBadChecksum && !isFrameError(err) &&
+		err != ErrClientClosed && err != context.Canceled && err != context.DeadlineExceeded
+}
@@ -0,0 +1,177 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReconnectPolicy_backoff(t *testing.T) {
+	p := &ReconnectPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+	target := []time.Duration{0, 100, 200, 400, 800, 1000, 1000}
+	for i, v := range target {
+		res := p.backoff(i)
+		if res != v*time.Millisecond {
+			t.Error("For attempt", i, "expected", v*time.Millisecond, "got", res)
+		}
+	}
+
+	// Delay isn't limited without MaxBackoff
+	p.MaxBackoff = 0
+	target = []time.Duration{0, 100, 200, 400, 800, 1600, 3200}
+	for i, v := range target {
+		res := p.backoff(i)
+		if res != v*time.Millisecond {
+			t.Error("For attempt", i, "expected", v*time.Millisecond, "got", res)
+		}
+	}
+
+	// Jitter keeps delay in range
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		res := p.backoff(2)
+		if res < 100*time.Millisecond || res > 300*time.Millisecond {
+			t.Error("Expected delay in 100ms...300ms, got", res)
+		}
+	}
+}
+
+type testisConnErrorpair struct {
+	err error
+	res bool
+}
+
+var testsisConnError = []testisConnErrorpair{
+	{nil, false},
+	{errors.New("Connection reset"), true},
+	{ErrBadChecksum, false},
+	{ErrFrameIncomplete, false},
+	{ErrFrameTooLong, false},
+	{ErrBadASCIIFrame, false},
+	{ErrClientClosed, false},
+	{context.Canceled, false},
+}
+
+func TestIsConnError(t *testing.T) {
+	for _, pair := range testsisConnError {
+		if res := isConnError(pair.err); res != pair.res {
+			t.Error("For", pair.err, "expected", pair.res, "got", res)
+		}
+	}
+}
+
+func TestModbusConnState_String(t *testing.T) {
+	if ConnStateConnected.String() != "Connected" {
+		t.Error("Expected Connected, got", ConnStateConnected.String())
+	}
+	if ModbusConnState(10).String() != "Unknown" {
+		t.Error("Expected Unknown, got", ModbusConnState(10).String())
+	}
+}
+
+func TestModbusClient_Reconnect(t *testing.T) {
+	srv, cl := startTestServer(t, ModbusTCP)
+	defer srv.Stop()
+	defer cl.Close()
+
+	var states []ModbusConnState
+	cl.Reconnect = &ReconnectPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2}
+	cl.OnStateChange = func(state ModbusConnState, err error) {
+		states = append(states, state)
+	}
+
+	// Break connection
+	cl.Conn.Close()
+	if _, err := cl.ReadHoldingRegisters(0, 1); err == nil {
+		t.Error("Expected error for broken connection, got nil")
+	}
+	if _, err := cl.ReadHoldingRegisters(0, 1); err != nil {
+		t.Error("Expected nil after reconnect, got", err)
+	}
+	target := []ModbusConnState{ConnStateDisconnected, ConnStateConnecting, ConnStateConnected}
+	if len(states) != len(target) {
+		t.Fatal("Expected", target, "got", states)
+	}
+	for i, v := range target {
+		if states[i] != v {
+			t.Error("Expected", target, "got", states)
+			break
+		}
+	}
+}
+
+func TestModbusClient_NoReconnect(t *testing.T) {
+	srv, cl := startTestServer(t, ModbusTCP)
+	defer srv.Stop()
+	defer cl.Close()
+
+	cl.Conn.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := cl.ReadHoldingRegisters(0, 1); err == nil {
+			t.Error("Expected error without reconnect policy, got nil")
+		}
+	}
+}
+
+func TestModbusClient_ReconnectAfterClose(t *testing.T) {
+	srv, cl := startTestServer(t, ModbusTCP)
+	defer srv.Stop()
+
+	var states []ModbusConnState
+	cl.Reconnect = &ReconnectPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2}
+	cl.OnStateChange = func(state ModbusConnState, err error) {
+		states = append(states, state)
+	}
+	conn := cl.Conn
+	conn.Close()
+	if _, err := cl.ReadHoldingRegisters(0, 1); err == nil {
+		t.Error("Expected error for broken connection, got nil")
+	}
+	cl.Close()
+	if _, err := cl.ReadHoldingRegisters(0, 1); !errors.Is(err, ErrClientClosed) {
+		t.Error("Expected", ErrClientClosed, "got", err)
+	}
+	if cl.Conn != conn || len(states) != 1 {
+		t.Error("Expected no redial after close, got", states)
+	}
+	if err := cl.connect(context.Background()); !errors.Is(err, ErrClientClosed) || cl.Conn != conn {
+		t.Error("Expected", ErrClientClosed, "got", err)
+	}
+}
+
+func TestModbusClient_ReconnectFirstDial(t *testing.T) {
+	// Free port without server
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+
+	cl, err := NewClient(port, "127.0.0.1", ModbusTCP, 1)
+	if err == nil {
+		t.Fatal("Expected error, got nil")
+	}
+	if _, err = cl.ReadHoldingRegisters(0, 1); !errors.Is(err, ErrNotConnected) {
+		t.Error("Expected", ErrNotConnected, "got", err)
+	}
+
+	md := new(ModbusData)
+	md.Init(10, 10, 10, 10)
+	srv := NewServer("127.0.0.1", port, ModbusTCP, md)
+	if err = srv.Start(); err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer srv.Stop()
+	defer cl.Close()
+	cl.Reconnect = &ReconnectPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2}
+	if _, err = cl.ReadHoldingRegisters(0, 1); err != nil {
+		t.Error("Expected nil after reconnect, got", err)
+	}
+}
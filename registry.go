@@ -0,0 +1,193 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Max length of data in PDU after function code
+const MaxPDUDataSize = 252
+
+// LengthFunc predicts PDU length (function code and data) by first bytes
+// of PDU, returns 0 if more bytes are needed for prediction
+type LengthFunc func(pdu []byte) int
+
+// Length rule for PDU of fixed length with function code, n must be from 1
+// to MaxPDUDataSize+1. Rule is checked by RegisterFunction.
+func FixedLength(n int) LengthFunc {
+	return func(pdu []byte) int {
+		return n
+	}
+}
+
+// Length rule for PDU with byte count field at pos followed by data, pos
+// must be from 1 to MaxPDUDataSize. Rule is checked by RegisterFunction.
+func ByteCountLength(pos int) LengthFunc {
+	return func(pdu []byte) int {
+		// Byte count can't replace function code
+		if pos < 1 {
+			return -1
+		}
+		return byteCountLength(pdu, pos)
+	}
+}
+
+// Checks length rule on received PDU of max size with zero data: length
+// must be predicted and fit PDU
+func checkLength(fc ModbusFunctionCode, length LengthFunc) error {
+	if length == nil {
+		return nil
+	}
+	pdu := make([]byte, MaxPDUDataSize+1)
+	pdu[0] = byte(fc)
+	if n := length(pdu); n < 1 || n > len(pdu) {
+		return fmt.Errorf("Bad length rule of function 0x%x, predicted length %d", byte(fc), n)
+	}
+	return nil
+}
+
+// ModbusFunction describes user-defined or vendor-specific function
+type ModbusFunction struct {
+	Name           string                                 // Name of function
+	RequestLength  LengthFunc                             // Length of request PDU, nil for unknown length
+	ResponseLength LengthFunc                             // Length of response PDU, nil for unknown length
+	Handler        Handler                                // Server handler, nil answers Illegal Function
+	EncodeRequest  func(req interface{}) ([]byte, error)  // Encode request data after function code
+	DecodeResponse func(data []byte) (interface{}, error) // Decode response data after function code
+}
+
+var (
+	functionsMu sync.RWMutex
+	functions   = make(map[ModbusFunctionCode]*ModbusFunction)
+)
+
+// Functions implemented by package, they can't be registered
+var builtinFunctions = map[ModbusFunctionCode]bool{
+	FcReadCoilStatus:             true,
+	FcReadDescreteInputs:         true,
+	FcReadHoldingRegisters:       true,
+	FcReadInputRegisters:         true,
+	FcForceSingleCoil:            true,
+	FcPresetSingleRegister:       true,
+	FcReadExceptionStatus:        true,
+	FcDiagnostics:                true,
+	FcGetCommEventCounter:        true,
+	FcGetCommEventLog:            true,
+	FcForceMultipleCoils:         true,
+	FcPresetMultipleRegisters:    true,
+	FcReportServerId:             true,
+	FcReadFileRecord:             true,
+	FcWriteFileRecord:            true,
+	FcMaskWriteRegister:          true,
+	FcReadWriteMultipleRegisters: true,
+	FcReadFIFOQueue:              true,
+	FcEncapsulatedInterface:      true,
+}
+
+// Register function for client, server and framer. Previous registration
+// of function code is replaced.
+func RegisterFunction(fc ModbusFunctionCode, f ModbusFunction) error {
+	if fc == 0 || byte(fc)&0x80 != 0 {
+		return fmt.Errorf("Bad function code 0x%x", byte(fc))
+	}
+	if builtinFunctions[fc] {
+		return fmt.Errorf("Function %s(0x%x) is built-in", fc, byte(fc))
+	}
+	if err := checkLength(fc, f.RequestLength); err != nil {
+		return err
+	}
+	if err := checkLength(fc, f.ResponseLength); err != nil {
+		return err
+	}
+	functionsMu.Lock()
+	defer functionsMu.Unlock()
+	functions[fc] = &f
+	return nil
+}
+
+// Remove registration of function code
+func UnregisterFunction(fc ModbusFunctionCode) {
+	functionsMu.Lock()
+	defer functionsMu.Unlock()
+	delete(functions, fc)
+}
+
+// Get registered function, nil if function code is not registered
+func lookupFunction(fc ModbusFunctionCode) *ModbusFunction {
+	functionsMu.RLock()
+	defer functionsMu.RUnlock()
+	return functions[fc]
+}
+
+// Predict PDU length of registered function, 0 and false if length is
+// unknown
+func registeredLength(pdu []byte, isAnswer bool) (int, bool) {
+	f := lookupFunction(ModbusFunctionCode(pdu[0]))
+	if f == nil {
+		return 0, false
+	}
+	length := f.RequestLength
+	if isAnswer {
+		length = f.ResponseLength
+	}
+	if length == nil {
+		return 0, false
+	}
+	return length(pdu), true
+}
+
+// Send Request with raw PDU data of function code, returns raw PDU of
+// answer: function code and data. Exception answer is returned with
+// ExceptionError.
+func (mc *ModbusClient) SendRawPDU(fc ModbusFunctionCode, data []byte) ([]byte, error) {
+	return mc.SendRawPDUContext(context.Background(), fc, data)
+}
+
+// Send Request with raw PDU data of function code, request is interrupted
+// when ctx is done
+func (mc *ModbusClient) SendRawPDUContext(ctx context.Context, fc ModbusFunctionCode, data []byte) ([]byte, error) {
+	if len(data) > MaxPDUDataSize {
+		return nil, ErrFrameTooLong
+	}
+	answer, err := mc.SendRequestContext(ctx, mc.buildRawRequest(fc, data))
+	if err != nil {
+		return nil, err
+	}
+	return answer.GetRawPDU(), answer.GetException()
+}
+
+// Build request with raw PDU data of function code
+func (mc *ModbusClient) buildRawRequest(fc ModbusFunctionCode, data []byte) *ModbusPacket {
+	request := &ModbusPacket{}
+	request.Init(mc.TypeProtocol)
+	request.buildRawPDU(mc.DevID, fc, data)
+	request.SetTransactionId(mc.GetTransactionId())
+	return request
+}
+
+// Call registered function: request is encoded, sent and response is
+// decoded by functions from registration
+func (mc *ModbusClient) CallFunction(ctx context.Context, fc ModbusFunctionCode, req interface{}) (interface{}, error) {
+	f := lookupFunction(fc)
+	if f == nil || f.EncodeRequest == nil || f.DecodeResponse == nil {
+		return nil, fmt.Errorf("Function 0x%x has no registered encoder and decoder", byte(fc))
+	}
+	data, err := f.EncodeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	pdu, err := mc.SendRawPDUContext(ctx, fc, data)
+	if err != nil {
+		return nil, err
+	}
+	if len(pdu) == 0 {
+		return nil, errors.New("Empty answer")
+	}
+	return f.DecodeResponse(pdu[1:])
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+// Vendor-specific function: request is 2 bytes, answer has byte count and
+// request bytes in reverse order
+const fcTestVendor ModbusFunctionCode = 65
+
+func registerTestVendor(t *testing.T) {
+	err := RegisterFunction(fcTestVendor, ModbusFunction{
+		Name:           "TestVendor",
+		RequestLength:  FixedLength(3),
+		ResponseLength: ByteCountLength(1),
+		Handler: HandlerFunc(func(ctx context.Context, request *ModbusPacket) (*ModbusPacket, error) {
+			pdu := request.GetRawPDU()
+			if len(pdu) != 3 {
+				return NewErrAnswer(request, ErrBadVal), errors.New("Bad request length")
+			}
+			return NewAnswer(request, []byte{2, pdu[2], pdu[1]}), nil
+		}),
+		EncodeRequest: func(req interface{}) ([]byte, error) {
+			v, ok := req.(uint16)
+			if !ok {
+				return nil, errors.New("Expected uint16")
+			}
+			return []byte{byte(v >> 8), byte(v)}, nil
+		},
+		DecodeResponse: func(data []byte) (interface{}, error) {
+			return uint16(data[1])<<8 | uint16(data[2]), nil
+		},
+	})
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+}
+
+func TestRegisterFunction(t *testing.T) {
+	registerTestVendor(t)
+	defer UnregisterFunction(fcTestVendor)
+
+	if fcTestVendor.String() != "TestVendor" {
+		t.Error("Expected TestVendor, got", fcTestVendor.String())
+	}
+	if err := RegisterFunction(FcReadHoldingRegisters, ModbusFunction{}); err == nil {
+		t.Error("Expected error, got nil")
+	}
+	if err := RegisterFunction(0x81, ModbusFunction{}); err == nil {
+		t.Error("Expected error, got nil")
+	}
+	if n, err := pduLength([]byte{65, 1}, false); n != 3 || err != nil {
+		t.Error("Expected 3, got", n, err)
+	}
+	if n, err := pduLength([]byte{65, 2}, true); n != 4 || err != nil {
+		t.Error("Expected 4, got", n, err)
+	}
+	if n, err := pduLength([]byte{66, 2}, true); n != 0 || err == nil {
+		t.Error("Expected error, got", n, err)
+	}
+}
+
+func TestModbusClient_SendRawPDU(t *testing.T) {
+	registerTestVendor(t)
+	defer UnregisterFunction(fcTestVendor)
+
+	for _, mbprotocol := range []ModbusTypeProtocol{ModbusTCP, ModbusRTUviaTCP, ModbusASCII} {
+		srv, cl := startTestServer(t, mbprotocol)
+		pdu, err := cl.SendRawPDU(fcTestVendor, []byte{0x12, 0x34})
+		target := []byte{byte(fcTestVendor), 2, 0x34, 0x12}
+		if err != nil || !bytes.Equal(pdu, target) {
+			t.Error("For", mbprotocol, "expected", target, "got", pdu, err)
+		}
+		res, err := cl.CallFunction(context.Background(), fcTestVendor, uint16(0x1234))
+		if err != nil || res != uint16(0x3412) {
+			t.Error("For", mbprotocol, "expected", 0x3412, "got", res, err)
+		}
+		// RTU frame is delimited by registered length, so short request
+		// can be checked by handler only with other protocols
+		if mbprotocol != ModbusRTUviaTCP {
+			_, err = cl.SendRawPDU(fcTestVendor, []byte{0x12})
+			if !errors.Is(err, ErrBadVal) {
+				t.Error("For", mbprotocol, "expected", ErrBadVal, "got", err)
+			}
+		}
+		// Function without handler
+		if _, err = cl.SendRawPDU(100, nil); !errors.Is(err, ErrCantHandel) {
+			t.Error("For", mbprotocol, "expected", ErrCantHandel, "got", err)
+		}
+		cl.Close()
+		srv.Stop()
+	}
+}
+
+func TestRegisterFunction_length(t *testing.T) {
+	for _, length := range []LengthFunc{
+		FixedLength(0),
+		FixedLength(MaxPDUDataSize + 2),
+		ByteCountLength(0),
+		ByteCountLength(MaxPDUDataSize + 1),
+	} {
+		if err := RegisterFunction(fcTestVendor, ModbusFunction{RequestLength: length}); err == nil {
+			UnregisterFunction(fcTestVendor)
+			t.Error("Expected error, got nil")
+		}
+		if err := RegisterFunction(fcTestVendor, ModbusFunction{ResponseLength: length}); err == nil {
+			UnregisterFunction(fcTestVendor)
+			t.Error("Expected error, got nil")
+		}
+	}
+	if lookupFunction(fcTestVendor) != nil {
+		t.Error("Expected nil, got registered function")
+	}
+	err := RegisterFunction(fcTestVendor, ModbusFunction{
+		RequestLength:  FixedLength(MaxPDUDataSize + 1),
+		ResponseLength: ByteCountLength(MaxPDUDataSize),
+	})
+	if err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	UnregisterFunction(fcTestVendor)
+	if n := ByteCountLength(MaxPDUDataSize)(make([]byte, 2)); n != 0 {
+		t.Error("Expected 0, got", n)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RetryPolicy describes repeating of requests failed by timeout, bad
+// checksum of answer or Server Busy and Acknowledge exceptions. Only
+// idempotent read functions are repeated by default, writes must be
+// allowed explicitly.
+type RetryPolicy struct {
+	MaxRetries    int                  // Retries after the first attempt
+	Delay         time.Duration        // Delay before retry
+	FunctionCodes []ModbusFunctionCode // Repeated functions, empty allows read functions only
+}
+
+// Functions repeated by default
+var retryDefaultFunctionCodes = []ModbusFunctionCode{
+	FcReadCoilStatus,
+	FcReadDescreteInputs,
+	FcReadHoldingRegisters,
+	FcReadInputRegisters,
+	FcReadExceptionStatus,
+	FcGetCommEventCounter,
+	FcGetCommEventLog,
+	FcReportServerId,
+	FcReadFileRecord,
+	FcReadFIFOQueue,
+	FcEncapsulatedInterface,
+}
+
+// Checks that request with function code can be repeated
+func (p *RetryPolicy) allows(fc ModbusFunctionCode) bool {
+	fcs := p.FunctionCodes
+	if len(fcs) == 0 {
+		fcs = retryDefaultFunctionCodes
+	}
+	for _, v := range fcs {
+		if v == fc {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks that result of request attempt must be repeated
+func (p *RetryPolicy) isRetryable(answer *ModbusPacket, err error) bool {
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return false
+	}
+	if err != nil {
+		return isTimeout(err) || err == ErrBadChecksum || isFrameError(err)
+	}
+	exc := answer.GetException()
+	return errors.Is(exc, ErrBusy) || errors.Is(exc, ErrAcknowledge)
+}
+
+// Send request and repeat it according to retry policy
+func (mc *ModbusClient) sendWithRetry(ctx context.Context, mp *ModbusPacket,
+	send func(context.Context, *ModbusPacket) (*ModbusPacket, error)) (*ModbusPacket, error) {
+	for attempt := 0; ; attempt++ {
+		answer, err := send(ctx, mp)
+		if err == nil {
+			if err = checkAnswer(mp, answer); err != nil {
+				answer = nil
+			}
+		}
+		p := mc.Retry
+		if p == nil || attempt >= p.MaxRetries || !p.allows(mp.GetFunctionCode()) || !p.isRetryable(answer, err) {
+			return answer, err
+		}
+		if p.Delay > 0 {
+			select {
+			case <-time.After(p.Delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testRetryablepair struct {
+	answer *ModbusPacket
+	err    error
+	res    bool
+}
+
+func TestRetryPolicy_isRetryable(t *testing.T) {
+	req := buildRequest(0, ModbusTCP, 1, FcReadHoldingRegisters, 0, 1)
+	tests := []testRetryablepair{
+		{buildAnswer(req, 2, 0, 1), nil, false},
+		{buildErrAnswer(req, ErrBusy), nil, true},
+		{buildErrAnswer(req, ErrAcknowledge), nil, true},
+		{buildErrAnswer(req, ErrOutside), nil, false},
+		{nil, ErrBadChecksum, true},
+		{nil, ErrFrameIncomplete, true},
+		{nil, ErrBadASCIIFrame, true},
+		{nil, &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, true},
+		{nil, errors.New("Connection reset"), false},
+	}
+	p := &RetryPolicy{MaxRetries: 1}
+	for _, pair := range tests {
+		if res := p.isRetryable(pair.answer, pair.err); res != pair.res {
+			t.Error("For", pair.answer, pair.err, "expected", pair.res, "got", res)
+		}
+	}
+}
+
+func TestRetryPolicy_allows(t *testing.T) {
+	p := &RetryPolicy{}
+	if !p.allows(FcReadHoldingRegisters) || p.allows(FcPresetSingleRegister) {
+		t.Error("Expected only read functions, got", p.allows(FcReadHoldingRegisters), p.allows(FcPresetSingleRegister))
+	}
+	p.FunctionCodes = []ModbusFunctionCode{FcPresetSingleRegister}
+	if p.allows(FcReadHoldingRegisters) || !p.allows(FcPresetSingleRegister) {
+		t.Error("Expected only", FcPresetSingleRegister, "got", p.allows(FcReadHoldingRegisters), p.allows(FcPresetSingleRegister))
+	}
+}
+
+// Start server which answers Server Busy to first busy requests
+func startBusyServer(t *testing.T, busy int32) (net.Listener, *ModbusClient, *int32) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	requests := new(int32)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fr := newFrameReader(conn, ModbusTCP, false)
+		for {
+			request := &ModbusPacket{}
+			request.Init(ModbusTCP)
+			if err := fr.ReadFrame(request); err != nil {
+				return
+			}
+			answer := buildErrAnswer(request, ErrBusy)
+			if atomic.AddInt32(requests, 1) > busy {
+				answer = buildAnswer(request, 0, 7)
+			}
+			conn.Write(answer.GetFrame())
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	cl, err := NewClient(port, "127.0.0.1", ModbusTCP, 1)
+	if err != nil {
+		ln.Close()
+		t.Fatal("Expected nil, got", err)
+	}
+	return ln, cl, requests
+}
+
+func TestModbusClient_Retry(t *testing.T) {
+	ln, cl, requests := startBusyServer(t, 2)
+	defer ln.Close()
+	defer cl.Close()
+
+	cl.Retry = &RetryPolicy{MaxRetries: 3, Delay: time.Millisecond}
+	res, err := cl.ReadHoldingRegisters(0, 1)
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	if len(res) != 1 || res[0] != 7 {
+		t.Error("Expected [7], got", res)
+	}
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Error("Expected 3 requests, got", n)
+	}
+}
+
+func TestModbusClient_RetryWrite(t *testing.T) {
+	ln, cl, requests := startBusyServer(t, 1)
+	defer ln.Close()
+	defer cl.Close()
+
+	// Writes are not repeated by default
+	cl.Retry = &RetryPolicy{MaxRetries: 3}
+	if err := cl.PresetSingleRegister(0, 7); !errors.Is(err, ErrBusy) {
+		t.Error("Expected", ErrBusy, "got", err)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Error("Expected 1 request, got", n)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+)
+
+// Port of Modbus/TCP Security
+const SecurityDefaultPort = "802"
+
+// Object identifier of certificate extension with Modbus role,
+// Modbus/TCP Security Protocol Specification
+var RoleOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 50316, 802, 1}
+
+// Rule allows requests of role to function codes and address range.
+// Rule with address range allows functions without address, like
+// Diagnostics, only if they are listed in FunctionCodes.
+type ModbusRule struct {
+	Role          string               // Role from client certificate
+	FunctionCodes []ModbusFunctionCode // Allowed function codes, empty allows all
+	Addr          uint16               // First allowed address
+	Cnt           uint16               // Count of allowed addresses, 0 allows all
+}
+
+// Get Modbus role from certificate, empty role if certificate has no role
+// extension
+func CertificateRole(cert *x509.Certificate) (string, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(RoleOID) {
+			continue
+		}
+		var role string
+		rest, err := asn1.Unmarshal(ext.Value, &role)
+		if err != nil {
+			return "", err
+		}
+		if len(rest) != 0 {
+			return "", errors.New("Trailing data in role extension")
+		}
+		return role, nil
+	}
+	return "", nil
+}
+
+// Get Modbus role from peer certificate of TLS connection
+func connectionRole(conn *tls.Conn) (string, error) {
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", errors.New("Client certificate is missing")
+	}
+	return CertificateRole(certs[0])
+}
+
+// Prepare TLS config for Modbus/TCP Security, TLS 1.2 or later is required
+func securityConfig(config *tls.Config, server bool) *tls.Config {
+	cfg := config.Clone()
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	if cfg.MinVersion < tls.VersionTLS12 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	// Mutual authentication
+	if server && cfg.ClientAuth == tls.NoClientCert {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg
+}
+
+// Address range of request
+type addrRange struct {
+	addr, cnt uint16
+}
+
+// Address ranges of request, nil if function has no address
+func requestRanges(mp *ModbusPacket) []addrRange {
+	par1, par2 := mp.GetFunctionParameters()
+	switch mp.GetFunctionCode() {
+	case FcReadCoilStatus, FcReadDescreteInputs, FcReadHoldingRegisters, FcReadInputRegisters,
+		FcForceMultipleCoils, FcPresetMultipleRegisters:
+		return []addrRange{{par1, par2}}
+	case FcForceSingleCoil, FcPresetSingleRegister, FcMaskWriteRegister:
+		return []addrRange{{par1, 1}}
+	case FcReadWriteMultipleRegisters:
+		pdu := mp.GetRawPDU()
+		if len(pdu) < 9 {
+			return []addrRange{{par1, par2}}
+		}
+		return []addrRange{{par1, par2}, {binary.BigEndian.Uint16(pdu[5:7]), binary.BigEndian.Uint16(pdu[7:9])}}
+	case FcReadFIFOQueue:
+		// Request contains only FIFO pointer address
+		pdu := mp.GetRawPDU()
+		if len(pdu) < 3 {
+			return nil
+		}
+		return []addrRange{{binary.BigEndian.Uint16(pdu[1:3]), 1}}
+	default:
+		return nil
+	}
+}
+
+// Checks that rule allows request
+func (r *ModbusRule) allows(role string, mp *ModbusPacket) bool {
+	if r.Role != role {
+		return false
+	}
+	listed := false
+	for _, fc := range r.FunctionCodes {
+		if fc == mp.GetFunctionCode() {
+			listed = true
+			break
+		}
+	}
+	if len(r.FunctionCodes) > 0 && !listed {
+		return false
+	}
+	if r.Cnt == 0 {
+		return true
+	}
+	ranges := requestRanges(mp)
+	if ranges == nil {
+		// Function without address is allowed by address range only if
+		// function code is listed
+		return listed
+	}
+	for _, ar := range ranges {
+		if uint32(ar.addr) < uint32(r.Addr) || uint32(ar.addr)+uint32(ar.cnt) > uint32(r.Addr)+uint32(r.Cnt) {
+			return false
+		}
+	}
+	return true
+}
+
+// Checks that one of rules allows request of role, nil rules allow all
+func isAuthorized(rules []ModbusRule, role string, mp *ModbusPacket) bool {
+	if rules == nil {
+		return true
+	}
+	for i := range rules {
+		if rules[i].allows(role, mp) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// Issue certificate signed by parent, self-signed if parent is nil
+func testCertificate(t *testing.T, name, role string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if role != "" {
+		value, _ := asn1.Marshal(role)
+		tmpl.ExtraExtensions = []pkix.Extension{{Id: RoleOID, Value: value}}
+	}
+	signer, signerKey := tmpl, interface{}(key)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer = parent.Leaf
+		signerKey = parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestCertificateRole(t *testing.T) {
+	cert := testCertificate(t, "client", "operator", nil)
+	role, err := CertificateRole(cert.Leaf)
+	if err != nil || role != "operator" {
+		t.Error("Expected operator, got", role, err)
+	}
+	cert = testCertificate(t, "client", "", nil)
+	role, err = CertificateRole(cert.Leaf)
+	if err != nil || role != "" {
+		t.Error("Expected empty role, got", role, err)
+	}
+}
+
+type testisAuthorizedpair struct {
+	role string
+	fc   ModbusFunctionCode
+	addr uint16
+	cnt  uint16
+	res  bool
+}
+
+var testsisAuthorized = []testisAuthorizedpair{
+	{"operator", FcReadHoldingRegisters, 0, 10, true},
+	{"operator", FcReadHoldingRegisters, 5, 6, false},
+	{"operator", FcPresetSingleRegister, 0, 1, false},
+	{"engineer", FcPresetSingleRegister, 100, 1, true},
+	{"engineer", FcForceMultipleCoils, 0, 1, true},
+	{"guest", FcReadHoldingRegisters, 0, 1, false},
+	{"operator", FcMaskWriteRegister, 0, 0xFF, false},
+	{"monitor", FcReadHoldingRegisters, 0, 10, true},
+	{"monitor", FcDiagnostics, 0x4, 0, false},
+	{"monitor", FcWriteFileRecord, 0x706, 0x1, false},
+	{"monitor", ModbusFunctionCode(0x41), 0, 1, false},
+	{"service", FcDiagnostics, 0x4, 0, true},
+	{"service", FcWriteFileRecord, 0x706, 0x1, false},
+}
+
+func TestIsAuthorized(t *testing.T) {
+	rules := []ModbusRule{
+		{Role: "operator", FunctionCodes: []ModbusFunctionCode{FcReadHoldingRegisters}, Addr: 0, Cnt: 10},
+		{Role: "engineer"},
+		{Role: "monitor", Addr: 0, Cnt: 10},
+		{Role: "service", FunctionCodes: []ModbusFunctionCode{FcDiagnostics}, Addr: 0, Cnt: 10},
+	}
+	for _, pair := range testsisAuthorized {
+		req := buildRequest(0, ModbusTCP, 1, pair.fc, pair.addr, pair.cnt)
+		res := isAuthorized(rules, pair.role, req)
+		if res != pair.res {
+			t.Error("For", pair, "expected", pair.res, "got", res)
+		}
+	}
+}
+
+func TestModbusServer_TLS(t *testing.T) {
+	ca := testCertificate(t, "ca", "", nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	srvCert := testCertificate(t, "server", "", &ca)
+	clCert := testCertificate(t, "client", "operator", &ca)
+
+	md := new(ModbusData)
+	md.Init(10, 10, 10, 10)
+	md.PresetMultipleRegisters(0, 0x01, 0x02)
+	srv := NewTLSServer("127.0.0.1", "0", ModbusTCP, md,
+		&tls.Config{Certificates: []tls.Certificate{srvCert}, ClientCAs: pool},
+		ModbusRule{Role: "operator", FunctionCodes: []ModbusFunctionCode{FcReadHoldingRegisters}})
+	if err := srv.Start(); err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer srv.Stop()
+	_, port, _ := net.SplitHostPort(srv.ln.Addr().String())
+
+	cl, err := NewTLSClient(port, "127.0.0.1", ModbusTCP, 1,
+		&tls.Config{Certificates: []tls.Certificate{clCert}, RootCAs: pool})
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer cl.Close()
+	res, err := cl.ReadHoldingRegisters(0, 2)
+	if err != nil || len(res) != 2 || res[0] != 0x01 || res[1] != 0x02 {
+		t.Error("Expected [1 2], got", res, err)
+	}
+	if err = cl.PresetMultipleRegisters(0, 1, 0x05); err == nil {
+		t.Error("Expected error for not authorized request, got nil")
+	}
+
+	// Client without certificate
+	cl, err = NewTLSClient(port, "127.0.0.1", ModbusTCP, 1, &tls.Config{RootCAs: pool})
+	if err == nil {
+		_, err = cl.ReadHoldingRegisters(0, 2)
+		cl.Close()
+	}
+	if err == nil {
+		t.Error("Expected error for client without certificate, got nil")
+	}
+}
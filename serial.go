@@ -0,0 +1,120 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// Default serial line settings, Modbus over Serial Line Specification
+// requires even parity as default
+const (
+	SerialDefaultBaudRate = 19200
+	SerialDefaultDataBits = 8
+	SerialDefaultParity   = "E"
+	SerialDefaultStopBits = 1
+)
+
+// SerialConfig describes serial line parameters
+type SerialConfig struct {
+	Address  string // Device path, for example /dev/ttyUSB0
+	BaudRate int    // Baud rate
+	DataBits int    // Data bits: 5, 6, 7 or 8
+	Parity   string // Parity: N - none, E - even, O - odd
+	StopBits int    // Stop bits: 1 or 2
+}
+
+// Fill empty fields by default values
+func (cfg *SerialConfig) setDefaults() {
+	if cfg.BaudRate == 0 {
+		cfg.BaudRate = SerialDefaultBaudRate
+	}
+	if cfg.DataBits == 0 {
+		cfg.DataBits = SerialDefaultDataBits
+	}
+	if cfg.Parity == "" {
+		cfg.Parity = SerialDefaultParity
+	}
+	if cfg.StopBits == 0 {
+		cfg.StopBits = SerialDefaultStopBits
+	}
+}
+
+// Return string with device path and line settings, for example
+// /dev/ttyUSB0 19200 8E1
+func (cfg *SerialConfig) String() string {
+	return fmt.Sprintf("%s %d %d%s%d", cfg.Address, cfg.BaudRate, cfg.DataBits, cfg.Parity, cfg.StopBits)
+}
+
+// Address of serial port
+type serialAddr string
+
+func (a serialAddr) Network() string {
+	return "serial"
+}
+
+func (a serialAddr) String() string {
+	return string(a)
+}
+
+// SerialPort implements net.Conn interface over serial line, so it can be
+// used everywhere where ModbusClient and ModbusServer use network connection
+type SerialPort struct {
+	f      *os.File     // Opened device
+	Config SerialConfig // Serial line settings
+}
+
+// OpenSerial opens and configures serial port
+func OpenSerial(cfg SerialConfig) (*SerialPort, error) {
+	cfg.setDefaults()
+	f, err := openSerial(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &SerialPort{f: f, Config: cfg}, nil
+}
+
+// Read data from serial port
+func (sp *SerialPort) Read(b []byte) (int, error) {
+	return sp.f.Read(b)
+}
+
+// Write data to serial port
+func (sp *SerialPort) Write(b []byte) (int, error) {
+	return sp.f.Write(b)
+}
+
+// Close serial port
+func (sp *SerialPort) Close() error {
+	return sp.f.Close()
+}
+
+// LocalAddr returns device path
+func (sp *SerialPort) LocalAddr() net.Addr {
+	return serialAddr(sp.Config.Address)
+}
+
+// RemoteAddr returns device path, serial line has no remote address
+func (sp *SerialPort) RemoteAddr() net.Addr {
+	return serialAddr(sp.Config.Address)
+}
+
+// SetDeadline sets the read and write deadlines
+func (sp *SerialPort) SetDeadline(t time.Time) error {
+	return sp.f.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline
+func (sp *SerialPort) SetReadDeadline(t time.Time) error {
+	return sp.f.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline
+func (sp *SerialPort) SetWriteDeadline(t time.Time) error {
+	return sp.f.SetWriteDeadline(t)
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+//go:build linux
+// +build linux
+
+package modbus
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Supported baud rates
+var serialBaudRates = map[int]uint32{
+	1200:   syscall.B1200,
+	2400:   syscall.B2400,
+	4800:   syscall.B4800,
+	9600:   syscall.B9600,
+	19200:  syscall.B19200,
+	38400:  syscall.B38400,
+	57600:  syscall.B57600,
+	115200: syscall.B115200,
+	230400: syscall.B230400,
+	460800: syscall.B460800,
+	921600: syscall.B921600,
+}
+
+// Supported data bits
+var serialDataBits = map[int]uint32{
+	5: syscall.CS5,
+	6: syscall.CS6,
+	7: syscall.CS7,
+	8: syscall.CS8,
+}
+
+func ioctl(fd uintptr, req uint, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(req), arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Build termios structure for passed serial line settings
+func serialTermios(cfg *SerialConfig) (*syscall.Termios, error) {
+	speed, ok := serialBaudRates[cfg.BaudRate]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported baud rate %d", cfg.BaudRate)
+	}
+	size, ok := serialDataBits[cfg.DataBits]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported data bits %d", cfg.DataBits)
+	}
+
+	// Raw mode, receiver is enabled, modem control lines are ignored.
+	// Speed is set by Cflag, some architectures have no Ispeed/Ospeed.
+	t := &syscall.Termios{
+		Cflag: syscall.CREAD | syscall.CLOCAL | size | speed,
+	}
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+
+	switch cfg.Parity {
+	case "N":
+		t.Iflag |= syscall.IGNPAR
+	case "E":
+		t.Cflag |= syscall.PARENB
+		t.Iflag |= syscall.INPCK
+	case "O":
+		t.Cflag |= syscall.PARENB | syscall.PARODD
+		t.Iflag |= syscall.INPCK
+	default:
+		return nil, fmt.Errorf("Unsupported parity %s", cfg.Parity)
+	}
+
+	switch cfg.StopBits {
+	case 1:
+	case 2:
+		t.Cflag |= syscall.CSTOPB
+	default:
+		return nil, fmt.Errorf("Unsupported stop bits %d", cfg.StopBits)
+	}
+
+	return t, nil
+}
+
+// Open device and set serial line settings
+func openSerial(cfg *SerialConfig) (*os.File, error) {
+	t, err := serialTermios(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Non blocking mode allows to use deadlines on the opened file
+	f, err := os.OpenFile(cfg.Address, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	var ioErr error
+	err = rc.Control(func(fd uintptr) {
+		if ioErr = ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(t))); ioErr != nil {
+			return
+		}
+		// Drop everything received before configuring
+		ioErr = ioctl(fd, serialTCFLSH, syscall.TCIOFLUSH)
+	})
+	if err == nil {
+		err = ioErr
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Can't configure serial port %s: %v", cfg.Address, err)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,12 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+//go:build linux && (mips || mipsle || mips64 || mips64le)
+// +build linux
+// +build mips mipsle mips64 mips64le
+
+package modbus
+
+// TCFLSH ioctl is missing in package syscall
+const serialTCFLSH = 0x5407
@@ -0,0 +1,172 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+//go:build linux
+// +build linux
+
+package modbus
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// Open pseudo-terminal, returns master side and path to slave side
+func openPty(t *testing.T) (*os.File, string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skip("Pseudo-terminals are not available:", err)
+	}
+	var n, unlock uint32
+	fd := master.Fd()
+	if err = ioctl(fd, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err == nil {
+		err = ioctl(fd, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n)))
+	}
+	if err != nil {
+		master.Close()
+		t.Skip("Pseudo-terminals are not available:", err)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+type testSerialTermiospair struct {
+	cfg SerialConfig
+	res bool
+}
+
+var testsSerialTermios = []testSerialTermiospair{
+	{SerialConfig{BaudRate: 9600, DataBits: 8, Parity: "N", StopBits: 1}, true},
+	{SerialConfig{BaudRate: 19200, DataBits: 8, Parity: "E", StopBits: 1}, true},
+	{SerialConfig{BaudRate: 115200, DataBits: 7, Parity: "O", StopBits: 2}, true},
+	{SerialConfig{BaudRate: 12345, DataBits: 8, Parity: "N", StopBits: 1}, false},
+	{SerialConfig{BaudRate: 9600, DataBits: 9, Parity: "N", StopBits: 1}, false},
+	{SerialConfig{BaudRate: 9600, DataBits: 8, Parity: "X", StopBits: 1}, false},
+	{SerialConfig{BaudRate: 9600, DataBits: 8, Parity: "N", StopBits: 3}, false},
+}
+
+func TestSerialTermios(t *testing.T) {
+	for _, pair := range testsSerialTermios {
+		_, err := serialTermios(&pair.cfg)
+		if (err == nil) != pair.res {
+			t.Error("For", pair.cfg.String(), "expected", pair.res, "got", err)
+		}
+	}
+}
+
+func TestOpenSerial(t *testing.T) {
+	master, slave := openPty(t)
+	defer master.Close()
+
+	port, err := OpenSerial(SerialConfig{Address: slave})
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer port.Close()
+
+	if port.Config.String() != slave+" 19200 8E1" {
+		t.Error("Expected", slave+" 19200 8E1", "got", port.Config.String())
+	}
+
+	test_data := []byte{0x1, 0x3, 0x0, 0x0, 0x0, 0xA, 0xC5, 0xCD}
+	master.Write(test_data)
+	buf := make([]byte, len(test_data))
+	port.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := port.Read(buf)
+	if err != nil || n != len(test_data) {
+		t.Fatal("Expected", len(test_data), "got", n, err)
+	}
+	for i, v := range test_data {
+		if buf[i] != v {
+			t.Error("Expected", v, "got", buf[i])
+		}
+	}
+
+	// Deadline must interrupt reading
+	port.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err = port.Read(buf)
+	if !isTimeout(err) {
+		t.Error("Expected timeout, got", err)
+	}
+}
+
+func TestModbusServer_Serial(t *testing.T) {
+	master, slave := openPty(t)
+	defer master.Close()
+
+	md := &ModbusData{holding_reg: make([]uint16, 10), mu_holding_regs: &sync.Mutex{}}
+	md.PresetMultipleRegisters(0, 0x01, 0x02)
+	srv := NewSerialServer(SerialConfig{Address: slave}, ModbusRTUviaTCP, md)
+	if err := srv.Start(); err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer srv.Stop()
+
+	req := buildRequest(0, ModbusRTUviaTCP, 1, FcReadHoldingRegisters, 0, 2)
+	master.Write(req.PDU[:req.GetPDULength()])
+
+	target := []byte{0x1, 0x3, 0x4, 0x0, 0x1, 0x0, 0x2}
+	AppendCrc16(&target)
+	buf := make([]byte, len(target))
+	master.SetReadDeadline(time.Now().Add(time.Second))
+	for n := 0; n < len(target); {
+		cnt, err := master.Read(buf[n:])
+		if err != nil {
+			t.Fatal("Expected answer, got", err)
+		}
+		n += cnt
+	}
+	for i, v := range target {
+		if buf[i] != v {
+			t.Error("Expected", v, "got", buf[i])
+		}
+	}
+}
+
+func TestRtuFrameReader_readBySilence(t *testing.T) {
+	master, slave := openPty(t)
+	defer master.Close()
+
+	// Long silent intervals: t1.5 = 13.75ms, t3.5 = 32ms
+	port, err := OpenSerial(SerialConfig{Address: slave, BaudRate: 1200})
+	if err != nil {
+		t.Fatal("Expected nil, got", err)
+	}
+	defer port.Close()
+
+	req := buildRequest(0, ModbusRTUviaTCP, 1, FcReadHoldingRegisters, 0, 2)
+	frame := req.PDU[:req.GetPDULength()]
+	go func() {
+		// Two frames separated by silence
+		master.Write(frame)
+		time.Sleep(100 * time.Millisecond)
+		master.Write(frame)
+		time.Sleep(100 * time.Millisecond)
+		// Frame with silence longer than t1.5 inside
+		master.Write(frame[:3])
+		time.Sleep(20 * time.Millisecond)
+		master.Write(frame[3:])
+	}()
+
+	fr := newFrameReader(port, ModbusRTUviaTCP, false)
+	mp := &ModbusPacket{}
+	mp.Init(ModbusRTUviaTCP)
+	for i := 0; i < 2; i++ {
+		port.SetReadDeadline(time.Now().Add(time.Second))
+		if err = fr.ReadFrame(mp); err != nil {
+			t.Fatal("Expected nil, got", err)
+		}
+		if mp.Length != len(frame) {
+			t.Error("Expected", len(frame), "got", mp.Length)
+		}
+	}
+	port.SetReadDeadline(time.Now().Add(time.Second))
+	if err = fr.ReadFrame(mp); err != ErrFrameIncomplete {
+		t.Error("Expected", ErrFrameIncomplete, "got", err)
+	}
+}
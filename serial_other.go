@@ -0,0 +1,18 @@
+// Copyright 2019 Sergey Soldatov. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the Apache license. See the LICENSE file for details.
+
+//go:build !linux
+// +build !linux
+
+package modbus
+
+import (
+	"errors"
+	"os"
+)
+
+// Serial line is supported only on Linux
+func openSerial(cfg *SerialConfig) (*os.File, error) {
+	return nil, errors.New("Serial port is not supported on this platform")
+}
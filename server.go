@@ -7,10 +7,16 @@
 package modbus
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"log"
 	"math"
 	"net"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -18,16 +24,33 @@ import (
 
 // ModbusServer implements server interface
 type ModbusServer struct {
-	ModbusBaseServer                    // Anonim ModbusBase implementation
-	TypeProtocol     ModbusTypeProtocol // Type of Modbus protocol: TCP or RTU over TCP
-	ln               net.Listener       // Listener
-	done             chan struct{}      // Chan for sending "done" command
-	exited           chan struct{}      // Chan for sending to main app signal that server is fully stopped
-	wg               sync.WaitGroup     // WaitGroup for waiting end all connection
+	ModbusBaseServer                          // Anonim ModbusBase implementation
+	TypeProtocol     ModbusTypeProtocol       // Type of Modbus protocol: TCP or RTU over TCP
+	ln               net.Listener             // Listener
+	done             chan struct{}            // Chan for sending "done" command
+	exited           chan struct{}            // Chan for sending to main app signal that server is fully stopped
+	wg               sync.WaitGroup           // WaitGroup for waiting end all connection
+	Serial           *SerialConfig            // Serial line settings, nil for TCP
+	port             *SerialPort              // Opened serial port
+	Network          string                   // Network for listening: tcp or udp
+	pc               net.PacketConn           // Listener for udp
+	TLSConfig        *tls.Config              // TLS settings for Modbus/TCP Security
+	Rules            []ModbusRule             // Authorization rules for roles, nil allows all
+	Units            map[byte]ModbusDataStore // Data of unit IDs, nil serves all unit IDs with Data
+	Handler          Handler                  // Handler of requests, nil uses RequestHadler
+	Identification   map[byte]string          // Objects of Read Device Identification
+	ServerId         []byte                   // Server ID of Report Server ID up to MaxPDUDataSize-2 bytes, nil answers Illegal Function
+	ExceptionStatus  func() byte              // Outputs of Read Exception Status, nil answers 0
+	Files            ModbusFileStore          // Files of file record access, nil answers Illegal Function
+	diag             diagnostics              // Counters and event log of serial line diagnostics
+	unitIDs          map[byte]bool            // Unit IDs which server responds to, nil responds to all
+	unitMu           sync.RWMutex             // Guards unitIDs
+	middleware       []Middleware             // Middlewares wrapping handler
+	chain            Handler                  // Handler wrapped by middlewares, built on start
 }
 
 // NewServer function initializate new instance of ModbusServer
-func NewServer(host, port string, mbprotocol ModbusTypeProtocol, md *ModbusData) *ModbusServer {
+func NewServer(host, port string, mbprotocol ModbusTypeProtocol, md ModbusDataStore) *ModbusServer {
 	srv := &ModbusServer{
 		TypeProtocol: mbprotocol,
 		done:         make(chan struct{}),
@@ -35,15 +58,140 @@ func NewServer(host, port string, mbprotocol ModbusTypeProtocol, md *ModbusData)
 	srv.Port = port
 	srv.Host = host
 	srv.Data = md
+	srv.Network = "tcp"
 	return srv
 }
 
+// NewUDPServer function initializate new instance of ModbusServer
+// listening for datagrams, one datagram is one request
+func NewUDPServer(host, port string, mbprotocol ModbusTypeProtocol, md ModbusDataStore) *ModbusServer {
+	srv := NewServer(host, port, mbprotocol, md)
+	srv.Network = "udp"
+	return srv
+}
+
+// NewTLSServer function initializate new instance of ModbusServer
+// for Modbus/TCP Security. Clients must be authenticated by certificates,
+// requests are authorized by rules for role from client certificate.
+func NewTLSServer(host, port string, mbprotocol ModbusTypeProtocol, md ModbusDataStore, config *tls.Config, rules ...ModbusRule) *ModbusServer {
+	srv := NewServer(host, port, mbprotocol, md)
+	srv.TLSConfig = securityConfig(config, true)
+	srv.Rules = rules
+	return srv
+}
+
+// NewSerialServer function initializate new instance of ModbusServer
+// working over serial line. ModbusRTUviaTCP framing is used for Modbus RTU.
+func NewSerialServer(cfg SerialConfig, mbprotocol ModbusTypeProtocol, md ModbusDataStore) *ModbusServer {
+	srv := NewServer("", "", mbprotocol, md)
+	srv.Serial = &cfg
+	return srv
+}
+
+// Add unit ID with own data, after that server answers only to added unit
+// IDs. Units must be added before start of server.
+func (srv *ModbusServer) AddUnit(devID byte, md ModbusDataStore) {
+	if srv.Units == nil {
+		srv.Units = make(map[byte]ModbusDataStore)
+	}
+	srv.Units[devID] = md
+}
+
+// Set unit IDs which server responds to, requests to other unit IDs are
+// silently ignored on all connections. Without unit IDs server responds to
+// all unit IDs. Broadcast requests are not filtered. Unit IDs can be changed
+// while server is running.
+func (srv *ModbusServer) SetUnitIDs(ids ...byte) {
+	srv.unitMu.Lock()
+	defer srv.unitMu.Unlock()
+	if len(ids) == 0 {
+		srv.unitIDs = nil
+		return
+	}
+	srv.unitIDs = make(map[byte]bool, len(ids))
+	for _, id := range ids {
+		srv.unitIDs[id] = true
+	}
+}
+
+// Get unit IDs which server responds to, nil if server responds to all
+// unit IDs
+func (srv *ModbusServer) GetUnitIDs() []byte {
+	srv.unitMu.RLock()
+	defer srv.unitMu.RUnlock()
+	if srv.unitIDs == nil {
+		return nil
+	}
+	ids := make([]byte, 0, len(srv.unitIDs))
+	for id := range srv.unitIDs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Checks that server responds to unit ID
+func (srv *ModbusServer) respondsTo(id byte) bool {
+	srv.unitMu.RLock()
+	defer srv.unitMu.RUnlock()
+	return srv.unitIDs == nil || srv.unitIDs[id]
+}
+
+// Get data for unit ID of request, nil if unit ID is unknown
+func (srv *ModbusServer) unitData(mp *ModbusPacket) ModbusDataStore {
+	if srv.Units == nil {
+		return srv.Data
+	}
+	return srv.Units[mp.GetDevID()]
+}
+
+// Add middlewares wrapping handler of requests, first added middleware is
+// outermost. Middlewares must be added before start of server.
+func (srv *ModbusServer) Use(mws ...Middleware) {
+	srv.middleware = append(srv.middleware, mws...)
+}
+
+// Get handler of requests wrapped by middlewares, chain is built on start
+// of server
+func (srv *ModbusServer) handler() Handler {
+	if srv.chain != nil {
+		return srv.chain
+	}
+	return srv.buildChain()
+}
+
+// Wrap handler of requests by middlewares, authorization by rules is
+// innermost
+func (srv *ModbusServer) buildChain() Handler {
+	h := srv.Handler
+	if h == nil {
+		h = srv
+	}
+	mws := make([]Middleware, 0, len(srv.middleware)+1)
+	mws = append(mws, srv.middleware...)
+	return Chain(h, append(mws, Authorization(srv.Rules...))...)
+}
+
+// Return string with host ip/name and port or serial line settings
+func (srv *ModbusServer) String() string {
+	if srv.Serial != nil {
+		return srv.Serial.String()
+	}
+	return srv.ModbusBaseServer.String()
+}
+
 // Stop function close listener and wait closing all connection
 func (srv *ModbusServer) Stop() error {
 	log.Println("Shutting down server...")
 	if srv.ln != nil {
 		srv.ln.Close()
 	}
+	if srv.port != nil {
+		srv.port.Close()
+	}
+	if srv.pc != nil {
+		srv.pc.Close()
+	}
 	close(srv.done)
 	srv.wg.Wait()
 	log.Println("Server is stopped")
@@ -56,9 +204,21 @@ func (srv *ModbusServer) Start() error {
 
 	log.Println("Server startup...")
 	log.Println("Listening at", srv)
+	srv.chain = srv.buildChain()
+
+	if srv.Serial != nil {
+		return srv.startSerial()
+	}
+	if srv.Network == "udp" {
+		return srv.startUDP()
+	}
 
 	// Listen for incoming connections.
-	srv.ln, err = net.Listen("tcp", srv.String())
+	if srv.TLSConfig != nil {
+		srv.ln, err = tls.Listen("tcp", srv.String(), srv.TLSConfig)
+	} else {
+		srv.ln, err = net.Listen("tcp", srv.String())
+	}
 	if err != nil {
 		log.Println("Error listening:", err.Error())
 		return err
@@ -85,6 +245,7 @@ func (srv *ModbusServer) Start() error {
 					continue
 				}
 				// Handle connections in a new goroutine.
+				srv.wg.Add(1)
 				go srv.handleRequest(conn)
 			}
 		}
@@ -94,11 +255,43 @@ func (srv *ModbusServer) Start() error {
 	return nil
 }
 
-// Handles incoming requests.
+// Listen for incoming datagrams
+func (srv *ModbusServer) startUDP() error {
+	var err error
+	srv.pc, err = net.ListenPacket("udp", srv.String())
+	if err != nil {
+		log.Println("Error listening:", err.Error())
+		return err
+	}
+	srv.wg.Add(1)
+	go srv.handleDatagrams()
+
+	log.Println("Server is started")
+	return nil
+}
+
+// Open serial port and handle requests from it
+func (srv *ModbusServer) startSerial() error {
+	var err error
+	srv.port, err = OpenSerial(*srv.Serial)
+	if err != nil {
+		log.Println("Error opening serial port:", err.Error())
+		return err
+	}
+	srv.wg.Add(1)
+	go srv.handleRequest(srv.port)
+
+	log.Println("Server is started")
+	return nil
+}
+
+// Handles incoming requests, connection must be added to wait group by
+// caller.
 func (srv *ModbusServer) handleRequest(conn net.Conn) error {
 	var (
 		id_packet int
 		err       error
+		role      string
 	)
 	// Close the connection when you're done with it.
 	defer func() {
@@ -106,15 +299,30 @@ func (srv *ModbusServer) handleRequest(conn net.Conn) error {
 		srv.wg.Done()
 		conn.Close()
 	}()
-	srv.wg.Add(1)
 	request := &ModbusPacket{}
 	request.Init(srv.TypeProtocol)
+	fr := newFrameReader(conn, srv.TypeProtocol, false)
 
 	log.Printf(
 		"Src->: %s Dst<-: %s\n",
 		conn.RemoteAddr(),
 		conn.LocalAddr())
 
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// Authenticate client and get its role
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if err = tlsConn.Handshake(); err == nil {
+			role, err = connectionRole(tlsConn)
+		}
+		conn.SetDeadline(time.Time{})
+		if err != nil {
+			log.Println("Error TLS handshake:", err.Error())
+			return err
+		}
+		log.Printf("Src->: %s, Role: %s\n", conn.RemoteAddr(), role)
+	}
+
+	ctx := peerContext(conn.RemoteAddr(), role)
 	// Read the incoming connection into the buffer.
 	for {
 		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
@@ -122,8 +330,15 @@ func (srv *ModbusServer) handleRequest(conn net.Conn) error {
 		case <-srv.done:
 			return nil
 		default:
-			request.Length, err = conn.Read(request.PDU)
+			err = fr.ReadFrame(request)
 			if err != nil {
+				// Serial line is never closed by remote side, just wait next request
+				if isFrameError(err) {
+					srv.diag.commError()
+				}
+				if srv.Serial != nil && (isTimeout(err) || isFrameError(err)) {
+					continue
+				}
 				log.Println("Error reading:", err.Error())
 				return err
 			}
@@ -131,26 +346,121 @@ func (srv *ModbusServer) handleRequest(conn net.Conn) error {
 			if request.Length == 0 {
 				continue
 			}
+			// Frame with bad checksum is silently dropped
+			if !request.IsChecksumGood() {
+				srv.diag.commError()
+				log.Println("Drop request:", ErrBadChecksum.Error())
+				continue
+			}
 
 			if id_packet++; id_packet == math.MaxInt32 {
 				id_packet = 0
 			}
 			log.Printf("Src->: %s, Packet ID:%d\n", conn.RemoteAddr(), id_packet)
-			request.Dump("****Request Dump****")
-			var answer *ModbusPacket
-			answer, err = srv.RequestHadler(request)
-			if err != nil {
-				log.Println("Error handle request:", err.Error())
-				break
+			if answer := srv.serveRequest(ctx, request); answer != nil {
+				conn.Write(answer.GetFrame())
 			}
-			answer.Dump("****Answer Dump****")
-			conn.Write(answer.PDU[:answer.GetPDULength()])
 		}
 	}
 	return err
 }
 
+// Handles incoming datagrams, one datagram is one request.
+func (srv *ModbusServer) handleDatagrams() {
+	defer func() {
+		log.Println("Stop listen incoming datagrams")
+		srv.pc.Close()
+		srv.wg.Done()
+	}()
+	// Enough for hex encoded ModbusASCII frame
+	buf := make([]byte, 2*srv.TypeProtocol.MaxSize()+3)
+	for {
+		select {
+		case <-srv.done:
+			return
+		default:
+			n, addr, err := srv.pc.ReadFrom(buf)
+			if err != nil {
+				if strings.Contains(err.Error(), "use of closed network connection") {
+					return
+				}
+				log.Println("Error reading:", err.Error())
+				continue
+			}
+
+			request := &ModbusPacket{}
+			request.Init(srv.TypeProtocol)
+			fr := newFrameReader(bytes.NewReader(buf[:n]), srv.TypeProtocol, false)
+			if err = fr.ReadFrame(request); err != nil {
+				srv.diag.commError()
+				log.Println("Error reading datagram from", addr, ":", err.Error())
+				continue
+			}
+			if !request.IsChecksumGood() {
+				srv.diag.commError()
+				log.Println("Drop datagram from", addr, ":", ErrBadChecksum.Error())
+				continue
+			}
+			log.Printf("Src->: %s\n", addr)
+			if answer := srv.serveRequest(peerContext(addr, ""), request); answer != nil {
+				srv.pc.WriteTo(answer.GetFrame(), addr)
+			}
+		}
+	}
+}
+
+// Handle request and build answer for it, returns nil if answer must not be sent
+func (srv *ModbusServer) serveRequest(ctx context.Context, request *ModbusPacket) *ModbusPacket {
+	request.Dump("****Request Dump****")
+	if !isBroadcast(request) && !srv.respondsTo(request.GetDevID()) {
+		// Request to other device on the bus
+		srv.diag.received(request, false)
+		return nil
+	}
+	addressed := srv.unitData(request) != nil || isBroadcast(request)
+	if srv.diag.received(request, addressed) && !isRestartCommunications(request) {
+		log.Println("Listen only mode, request is not served")
+		if addressed {
+			srv.diag.answered(request, nil)
+		}
+		return nil
+	}
+	// Broadcast request is never answered
+	if isBroadcast(request) {
+		srv.serveBroadcast(ctx, request)
+		srv.diag.answered(request, nil)
+		return nil
+	}
+	answer, err := srv.handler().ServeModbus(ctx, request)
+	if err != nil {
+		// Answer contains exception
+		log.Println("Error handle request:", err.Error())
+	}
+	if addressed {
+		srv.diag.answered(request, answer)
+	}
+	if answer != nil {
+		answer.Dump("****Answer Dump****")
+	}
+	return answer
+}
+
+// Handle request by built-in handlers
 func (srv *ModbusServer) RequestHadler(mp *ModbusPacket) (*ModbusPacket, error) {
+	return srv.ServeModbus(context.Background(), mp)
+}
+
+// Serve request by built-in handlers or handler of registered function,
+// server is default Handler
+func (srv *ModbusServer) ServeModbus(ctx context.Context, mp *ModbusPacket) (*ModbusPacket, error) {
+	if srv.unitData(mp) == nil {
+		err := fmt.Errorf("Unknown unit ID %d", mp.GetDevID())
+		// Gateway answers for missing device, device on serial line is silent
+		if mp.TypeProtocol == ModbusTCP {
+			return buildErrAnswer(mp, ErrGatewayTarget), err
+		}
+		return nil, err
+	}
 	switch mp.GetFunctionCode() {
 	case FcReadCoilStatus:
 		return srv.ReadCoilStatus(mp)
@@ -168,100 +478,270 @@ func (srv *ModbusServer) RequestHadler(mp *ModbusPacket) (*ModbusPacket, error)
 		return srv.ForceMultipleCoils(mp)
 	case FcPresetMultipleRegisters:
 		return srv.PresetMultipleRegisters(mp)
+	case FcMaskWriteRegister:
+		return srv.MaskWriteRegister(mp)
+	case FcReadWriteMultipleRegisters:
+		return srv.ReadWriteMultipleRegisters(mp)
+	case FcReadFIFOQueue:
+		return srv.ReadFIFOQueue(mp)
+	case FcEncapsulatedInterface:
+		return srv.EncapsulatedInterface(mp)
+	case FcReadExceptionStatus:
+		return srv.ReadExceptionStatus(mp)
+	case FcDiagnostics:
+		return srv.Diagnostics(mp)
+	case FcGetCommEventCounter:
+		return srv.GetCommEventCounter(mp)
+	case FcGetCommEventLog:
+		return srv.GetCommEventLog(mp)
+	case FcReportServerId:
+		return srv.ReportServerId(mp)
+	case FcReadFileRecord:
+		return srv.ReadFileRecord(mp)
+	case FcWriteFileRecord:
+		return srv.WriteFileRecord(mp)
 	default:
+		if f := lookupFunction(mp.GetFunctionCode()); f != nil && f.Handler != nil {
+			return f.Handler.ServeModbus(ctx, mp)
+		}
 		return buildErrAnswer(mp, ErrCantHandel), errors.New("Unknown function code")
 	}
 }
 
+// Build answer with byte count and data, answer which doesn't fit into PDU
+// is exception
+func buildDataAnswer(mp *ModbusPacket, data []byte) (*ModbusPacket, error) {
+	if len(data) > MaxPDUDataSize-1 {
+		return buildErrAnswer(mp, ErrBadVal), fmt.Errorf("Answer data of %d bytes doesn't fit into PDU", len(data))
+	}
+	return buildAnswer(mp, data...), nil
+}
+
+// Exception code for error of data store: ModbusErrors returned by store
+// or Server Device Failure for other errors
+func storeErrorCode(err error) ModbusErrors {
+	var code ModbusErrors
+	if errors.As(err, &code) {
+		return code
+	}
+	return ErrDeviceFailure
+}
+
+// Checks that data store returned requested count of values
+func checkStoreCount(n int, cnt uint16) error {
+	if n != int(cnt) {
+		return fmt.Errorf("Data store returned %d values instead of %d", n, cnt)
+	}
+	return nil
+}
+
+// Checks read request: length of request and quantity
+func checkReadRequest(mp *ModbusPacket, max int) (uint16, uint16, error) {
+	if len(mp.GetRawPDU()) != 5 {
+		return 0, 0, errors.New("Bad length of request")
+	}
+	addr, cnt := mp.GetFunctionParameters()
+	return addr, cnt, checkQuantity(cnt, max)
+}
+
+// Checks write multiple request: length of request, quantity and byte count
+func checkWriteRequest(mp *ModbusPacket, max int, bytesPerCnt func(uint16) int) (uint16, uint16, []byte, error) {
+	if len(mp.GetRawPDU()) < 6 {
+		return 0, 0, nil, errors.New("Bad length of request")
+	}
+	addr, cnt := mp.GetFunctionParameters()
+	if err := checkQuantity(cnt, max); err != nil {
+		return 0, 0, nil, err
+	}
+	byte_cnt, data := mp.GetData()
+	if int(byte_cnt) != bytesPerCnt(cnt) || len(data) != int(byte_cnt) || len(mp.GetRawPDU()) != 6+len(data) {
+		return 0, 0, nil, errors.New("Byte count doesn't match quantity")
+	}
+	return addr, cnt, data, nil
+}
+
+// Byte count of registers
+func registersByteCnt(cnt uint16) int {
+	return 2 * int(cnt)
+}
+
+// Byte count of coils
+func coilsByteCnt(cnt uint16) int {
+	return int(boolCntToByteCnt(cnt))
+}
+
 // Read Holding registers
 func (srv *ModbusServer) ReadHoldingRegisters(mp *ModbusPacket) (*ModbusPacket, error) {
-	addr, cnt := mp.GetFunctionParameters()
+	addr, cnt, err := checkReadRequest(mp, MaxReadRegisters)
+	if err != nil {
+		return buildErrAnswer(mp, ErrBadVal), err
+	}
 	// Try get data for answer
-	data, err := srv.Data.ReadHoldingRegisters(addr, cnt)
+	data, err := srv.unitData(mp).ReadHoldingRegisters(addr, cnt)
+	if err == nil {
+		err = checkStoreCount(len(data), cnt)
+	}
 	if err != nil {
-		return buildErrAnswer(mp, 2), err
+		return buildErrAnswer(mp, storeErrorCode(err)), err
 	}
-	return buildAnswer(mp, wordArrToByteArr(data)...), nil
+	return buildDataAnswer(mp, wordArrToByteArr(data))
 }
 
 // Read Inputs registers
 func (srv *ModbusServer) ReadInputRegisters(mp *ModbusPacket) (*ModbusPacket, error) {
-	addr, cnt := mp.GetFunctionParameters()
+	addr, cnt, err := checkReadRequest(mp, MaxReadRegisters)
+	if err != nil {
+		return buildErrAnswer(mp, ErrBadVal), err
+	}
 	// Try get data for answer
-	data, err := srv.Data.ReadInputRegisters(addr, cnt)
+	data, err := srv.unitData(mp).ReadInputRegisters(addr, cnt)
+	if err == nil {
+		err = checkStoreCount(len(data), cnt)
+	}
 	if err != nil {
-		return buildErrAnswer(mp, 2), err
+		return buildErrAnswer(mp, storeErrorCode(err)), err
 	}
-	return buildAnswer(mp, wordArrToByteArr(data)...), nil
+	return buildDataAnswer(mp, wordArrToByteArr(data))
 }
 
 // Preset Single Register
 func (srv *ModbusServer) PresetSingleRegister(mp *ModbusPacket) (*ModbusPacket, error) {
+	if len(mp.GetRawPDU()) != 5 {
+		return buildErrAnswer(mp, ErrBadVal), errors.New("Bad length of request")
+	}
 	addr, value := mp.GetFunctionParameters()
-	// Set values in ModbusData
-	err := srv.Data.PresetSingleRegister(addr, value)
+	// Set values in data store
+	err := srv.unitData(mp).PresetMultipleRegisters(addr, value)
 	if err != nil {
-		return buildErrAnswer(mp, 2), err
+		return buildErrAnswer(mp, storeErrorCode(err)), err
 	}
 	return buildAnswer(mp), nil
 }
 
 // Preset Multiple Holding Registers
 func (srv *ModbusServer) PresetMultipleRegisters(mp *ModbusPacket) (*ModbusPacket, error) {
-	addr, _ := mp.GetFunctionParameters()
-	_, data := mp.GetData()
-	// Set values in ModbusData
-	err := srv.Data.PresetMultipleRegisters(addr, byteArrToWordArr(data)...)
+	addr, _, data, err := checkWriteRequest(mp, MaxWriteRegisters, registersByteCnt)
+	if err != nil {
+		return buildErrAnswer(mp, ErrBadVal), err
+	}
+	// Set values in data store
+	err = srv.unitData(mp).PresetMultipleRegisters(addr, byteArrToWordArr(data)...)
 	if err != nil {
-		return buildErrAnswer(mp, 2), err
+		return buildErrAnswer(mp, storeErrorCode(err)), err
 	}
 	return buildAnswer(mp), nil
 }
 
 // Read Coil Status
 func (srv *ModbusServer) ReadCoilStatus(mp *ModbusPacket) (*ModbusPacket, error) {
-	addr, cnt := mp.GetFunctionParameters()
+	addr, cnt, err := checkReadRequest(mp, MaxReadCoils)
+	if err != nil {
+		return buildErrAnswer(mp, ErrBadVal), err
+	}
 	// Data for answer
-	data, err := srv.Data.ReadCoilStatus(addr, cnt)
+	data, err := srv.unitData(mp).ReadCoilStatus(addr, cnt)
+	if err == nil {
+		err = checkStoreCount(len(data), cnt)
+	}
 	if err != nil {
-		return buildErrAnswer(mp, 2), err
+		return buildErrAnswer(mp, storeErrorCode(err)), err
 	}
-	return buildAnswer(mp, boolArrToByteArr(data)...), nil
+	return buildDataAnswer(mp, boolArrToByteArr(data))
 }
 
 // Read Descrete Inputs
 func (srv *ModbusServer) ReadDescreteInputs(mp *ModbusPacket) (*ModbusPacket, error) {
-	addr, cnt := mp.GetFunctionParameters()
+	addr, cnt, err := checkReadRequest(mp, MaxReadCoils)
+	if err != nil {
+		return buildErrAnswer(mp, ErrBadVal), err
+	}
 	// Data for answer
-	data, err := srv.Data.ReadDescreteInputs(addr, cnt)
+	data, err := srv.unitData(mp).ReadDescreteInputs(addr, cnt)
+	if err == nil {
+		err = checkStoreCount(len(data), cnt)
+	}
 	if err != nil {
-		return buildErrAnswer(mp, 2), err
+		return buildErrAnswer(mp, storeErrorCode(err)), err
 	}
-	return buildAnswer(mp, boolArrToByteArr(data)...), nil
+	return buildDataAnswer(mp, boolArrToByteArr(data))
 }
 
-// Force Single Coil
+// Force Single Coil, value must be 0xFF00 (ON) or 0x0000 (OFF)
 func (srv *ModbusServer) ForceSingleCoil(mp *ModbusPacket) (*ModbusPacket, error) {
+	if len(mp.GetRawPDU()) != 5 {
+		return buildErrAnswer(mp, ErrBadVal), errors.New("Bad length of request")
+	}
 	addr, value := mp.GetFunctionParameters()
-	if value == 0xFF00 {
-		value = 1
+	if value != 0xFF00 && value != 0 {
+		return buildErrAnswer(mp, ErrBadVal), fmt.Errorf("Bad value 0x%x of coil", value)
 	}
-	// Set values in ModbusData
-	err := srv.Data.ForceSingleCoil(addr, bool((value&1) == 1))
+	// Set values in data store
+	err := srv.unitData(mp).ForceMultipleCoils(addr, value == 0xFF00)
 	if err != nil {
-		return buildErrAnswer(mp, 2), err
+		return buildErrAnswer(mp, storeErrorCode(err)), err
 	}
 	return buildAnswer(mp), nil
 }
 
 // Force Multiple Coils
 func (srv *ModbusServer) ForceMultipleCoils(mp *ModbusPacket) (*ModbusPacket, error) {
-	addr, cnt := mp.GetFunctionParameters()
-	_, data := mp.GetData()
-	// Set values in ModbusData)
-	err := srv.Data.ForceMultipleCoils(addr, byteArrToBoolArr(data, byte(cnt))...)
+	addr, cnt, data, err := checkWriteRequest(mp, MaxWriteCoils, coilsByteCnt)
 	if err != nil {
-		return buildErrAnswer(mp, 2), err
+		return buildErrAnswer(mp, ErrBadVal), err
+	}
+	// Set values in data store
+	err = srv.unitData(mp).ForceMultipleCoils(addr, byteArrToBoolArr(data, cnt)...)
+	if err != nil {
+		return buildErrAnswer(mp, storeErrorCode(err)), err
 	}
 	return buildAnswer(mp), nil
 }
+
+// Mask Write Register
+func (srv *ModbusServer) MaskWriteRegister(mp *ModbusPacket) (*ModbusPacket, error) {
+	pdu := mp.GetRawPDU()
+	if len(pdu) != 7 {
+		return buildErrAnswer(mp, ErrBadVal), errors.New("Bad length of request")
+	}
+	addr := binary.BigEndian.Uint16(pdu[1:3])
+	and_mask := binary.BigEndian.Uint16(pdu[3:5])
+	or_mask := binary.BigEndian.Uint16(pdu[5:7])
+	// Set values in data store
+	err := maskWriteRegister(srv.unitData(mp), addr, and_mask, or_mask)
+	if err != nil {
+		return buildErrAnswer(mp, storeErrorCode(err)), err
+	}
+	// Answer is echo of request
+	return NewAnswer(mp, pdu[1:]), nil
+}
+
+// Read/Write Multiple Registers
+func (srv *ModbusServer) ReadWriteMultipleRegisters(mp *ModbusPacket) (*ModbusPacket, error) {
+	pdu := mp.GetRawPDU()
+	if len(pdu) < 10 {
+		return buildErrAnswer(mp, ErrBadVal), errors.New("Bad length of request")
+	}
+	read_addr := binary.BigEndian.Uint16(pdu[1:3])
+	read_cnt := binary.BigEndian.Uint16(pdu[3:5])
+	write_addr := binary.BigEndian.Uint16(pdu[5:7])
+	write_cnt := binary.BigEndian.Uint16(pdu[7:9])
+	if err := checkQuantity(read_cnt, MaxReadRegisters); err != nil {
+		return buildErrAnswer(mp, ErrBadVal), err
+	}
+	if err := checkQuantity(write_cnt, MaxReadWriteRegisters); err != nil {
+		return buildErrAnswer(mp, ErrBadVal), err
+	}
+	data := pdu[10:]
+	if int(pdu[9]) != len(data) || len(data) != 2*int(write_cnt) {
+		return buildErrAnswer(mp, ErrBadVal), errors.New("Byte count doesn't match quantity of registers")
+	}
+	// Write and read values in data store
+	res, err := readWriteMultipleRegisters(srv.unitData(mp), read_addr, read_cnt, write_addr, byteArrToWordArr(data)...)
+	if err == nil {
+		err = checkStoreCount(len(res), read_cnt)
+	}
+	if err != nil {
+		return buildErrAnswer(mp, storeErrorCode(err)), err
+	}
+	return NewAnswer(mp, append([]byte{byte(2 * len(res))}, wordArrToByteArr(res)...)), nil
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/binary"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestModbusServer_ReadHoldingRegisters(t *testing.T) {
@@ -67,7 +68,7 @@ func TestModbusServer_ReadCoilStatus(t *testing.T) {
 	req := buildRequest(0, ModbusRTUviaTCP, 1, FcReadCoilStatus, 0, 0x5)
 	answ, _ := srv.ReadCoilStatus(req)
 	_, answ_data := answ.GetData()
-	bool_arr := byteArrToBoolArr(answ_data, byte(len(test_data)))
+	bool_arr := byteArrToBoolArr(answ_data, uint16(len(test_data)))
 	for i, v := range test_data {
 		if bool_arr[i] != v {
 			t.Error("Expected ", v, "got ", bool_arr[i])
@@ -98,7 +99,7 @@ func TestModbusServer_ReadDescreteInputs(t *testing.T) {
 	req := buildRequest(0, ModbusRTUviaTCP, 1, FcReadDescreteInputs, 0, 0x5)
 	answ, _ := srv.ReadDescreteInputs(req)
 	_, answ_data := answ.GetData()
-	bool_arr := byteArrToBoolArr(answ_data, byte(len(test_data)))
+	bool_arr := byteArrToBoolArr(answ_data, uint16(len(test_data)))
 	for i, v := range test_data {
 		if bool_arr[i] != v {
 			t.Error("Expected ", v, "got ", bool_arr[i])
@@ -131,3 +132,155 @@ func TestModbusServer_TestForceSingleCoil(t *testing.T) {
 		t.Error("Expected ", true, "got ", md.coils[test_addr])
 	}
 }
+
+func TestModbusServer_Units(t *testing.T) {
+	for _, mbprotocol := range []ModbusTypeProtocol{ModbusTCP, ModbusRTUviaTCP, ModbusASCII} {
+		srv := &ModbusServer{}
+		for _, devID := range []byte{1, 2} {
+			md := new(ModbusData)
+			md.Init(10, 10, 10, 10)
+			md.PresetSingleRegister(0, uint16(devID))
+			srv.AddUnit(devID, md)
+		}
+		for _, devID := range []byte{1, 2} {
+			answ, err := srv.RequestHadler(buildRequest(0, mbprotocol, devID, FcReadHoldingRegisters, 0, 1))
+			if err != nil {
+				t.Error("For", mbprotocol, "expected nil, got", err)
+				continue
+			}
+			_, answ_data := answ.GetData()
+			if len(answ_data) != 2 || answ_data[1] != devID {
+				t.Error("For", mbprotocol, "expected", devID, "got", answ_data)
+			}
+		}
+
+		answ, err := srv.RequestHadler(buildRequest(0, mbprotocol, 3, FcReadHoldingRegisters, 0, 1))
+		if err == nil {
+			t.Error("For", mbprotocol, "expected error, got nil")
+		}
+		if mbprotocol == ModbusTCP {
+			if answ == nil || answ.GetErrorCode() != ErrGatewayTarget {
+				t.Error("For", mbprotocol, "expected exception", ErrGatewayTarget, "got", answ)
+			}
+		} else if answ != nil {
+			t.Error("For", mbprotocol, "expected nil, got", answ)
+		}
+	}
+}
+
+type testValidateRequestpair struct {
+	fc  ModbusFunctionCode
+	req []byte
+	err ModbusErrors
+}
+
+var testsValidateRequest = []testValidateRequestpair{
+	{FcReadHoldingRegisters, []byte{0x0, 0x0, 0x0, 0x7D}, ErrOutside},
+	{FcReadHoldingRegisters, []byte{0x0, 0x0, 0x0, 0x7E}, ErrBadVal},
+	{FcReadHoldingRegisters, []byte{0x0, 0x0, 0x0, 0x0}, ErrBadVal},
+	{FcReadHoldingRegisters, []byte{0xFF, 0xFF, 0x0, 0x2}, ErrOutside},
+	{FcReadHoldingRegisters, []byte{0x0, 0x0, 0x0}, ErrBadVal},
+	{FcReadInputRegisters, []byte{0x0, 0x0, 0x0, 0x7E}, ErrBadVal},
+	{FcReadCoilStatus, []byte{0x0, 0x0, 0x7, 0xD0}, ErrOutside},
+	{FcReadCoilStatus, []byte{0x0, 0x0, 0x7, 0xD1}, ErrBadVal},
+	{FcReadDescreteInputs, []byte{0x0, 0x0, 0x0, 0x0}, ErrBadVal},
+	{FcForceSingleCoil, []byte{0x0, 0x0, 0xFF, 0x00}, 0},
+	{FcForceSingleCoil, []byte{0x0, 0x0, 0x0, 0x1}, ErrBadVal},
+	{FcForceSingleCoil, []byte{0x0, 0xA, 0x0, 0x0}, ErrOutside},
+	{FcPresetSingleRegister, []byte{0x0, 0xA, 0x0, 0x0}, ErrOutside},
+	{FcPresetMultipleRegisters, []byte{0x0, 0x0, 0x0, 0x1, 0x2, 0x0, 0x1}, 0},
+	{FcPresetMultipleRegisters, []byte{0x0, 0x0, 0x0, 0x1, 0x4, 0x0, 0x1, 0x0, 0x2}, ErrBadVal},
+	{FcPresetMultipleRegisters, []byte{0x0, 0x0, 0x0, 0x2, 0x4, 0x0, 0x1}, ErrBadVal},
+	{FcPresetMultipleRegisters, []byte{0x0, 0x0, 0x0, 0x7C, 0xF8}, ErrBadVal},
+	{FcPresetMultipleRegisters, []byte{0x0, 0x9, 0x0, 0x2, 0x4, 0x0, 0x1, 0x0, 0x2}, ErrOutside},
+	{FcForceMultipleCoils, []byte{0x0, 0x0, 0x0, 0x9, 0x2, 0xFF, 0x1}, 0},
+	{FcForceMultipleCoils, []byte{0x0, 0x0, 0x0, 0x9, 0x1, 0xFF}, ErrBadVal},
+	{FcForceMultipleCoils, []byte{0x0, 0x0, 0x7, 0xB1, 0xF7}, ErrBadVal},
+	{FcReadWriteMultipleRegisters, []byte{0x0, 0x0, 0x0, 0x7E, 0x0, 0x0, 0x0, 0x1, 0x2, 0x0, 0x1}, ErrBadVal},
+	{FcReadWriteMultipleRegisters, []byte{0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0}, ErrBadVal},
+	{FcReadWriteMultipleRegisters, []byte{0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x0, 0x1, 0x2, 0x0, 0x1}, 0},
+}
+
+func TestModbusServer_ValidateRequest(t *testing.T) {
+	md := new(ModbusData)
+	md.Init(10, 10, 10, 10)
+	srv := &ModbusServer{}
+	srv.Data = md
+	for _, pair := range testsValidateRequest {
+		for _, mbprotocol := range []ModbusTypeProtocol{ModbusTCP, ModbusRTUviaTCP} {
+			req := &ModbusPacket{}
+			req.Init(mbprotocol)
+			req.buildRawPDU(1, pair.fc, pair.req)
+			answ, _ := srv.RequestHadler(req)
+			if answ.IsException() != (pair.err != 0) || (pair.err != 0 && answ.GetErrorCode() != pair.err) {
+				t.Error("For", mbprotocol, pair, "expected exception", pair.err, "got", answ.GetException())
+			}
+		}
+	}
+}
+
+func TestModbusServer_MalformedRequest(t *testing.T) {
+	md := new(ModbusData)
+	md.Init(10, 10, 10, 10)
+	srv := &ModbusServer{Identification: map[byte]string{0: "Vendor"}, ServerId: []byte{1}, Files: &ModbusFiles{}}
+	srv.Data = md
+	data := []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+	// Truncated requests of all functions are answered without panic
+	for fc := 1; fc < 0x80; fc++ {
+		for n := 0; n <= len(data); n++ {
+			req := &ModbusPacket{}
+			req.Init(ModbusRTUviaTCP)
+			req.buildRawPDU(1, ModbusFunctionCode(fc), data[:n])
+			srv.RequestHadler(req)
+		}
+	}
+}
+
+func TestModbusServer_BadCrc(t *testing.T) {
+	srv, cl := startTestServer(t, ModbusRTUviaTCP)
+	defer srv.Stop()
+	defer cl.Close()
+	cl.Timeout = 100 * time.Millisecond
+	// Request with bad CRC is dropped silently
+	request := buildRequest(0, ModbusRTUviaTCP, 1, FcReadHoldingRegisters, 0, 1)
+	request.aPDU[request.Length-1] ^= 0xFF
+	if _, err := cl.SendRequest(request); !isTimeout(err) {
+		t.Error("Expected timeout, got", err)
+	}
+	if counters := srv.GetCounters(); counters.BusCommErrors != 1 || counters.BusMessages != 0 {
+		t.Error("Expected 1 communication error, got", counters)
+	}
+	if _, err := cl.ReadHoldingRegisters(0, 1); err != nil {
+		t.Error("Expected nil, got", err)
+	}
+}
+
+func TestModbusServer_SetUnitIDs(t *testing.T) {
+	srv, cl := startTestServer(t, ModbusRTUviaTCP)
+	defer srv.Stop()
+	defer cl.Close()
+	cl.Timeout = 100 * time.Millisecond
+	srv.SetUnitIDs(2, 3)
+	if ids := srv.GetUnitIDs(); len(ids) != 2 || ids[0] != 2 || ids[1] != 3 {
+		t.Error("Expected [2 3], got", ids)
+	}
+	// Request to other device is ignored
+	if _, err := cl.ReadHoldingRegisters(0, 1); !isTimeout(err) {
+		t.Error("Expected timeout, got", err)
+	}
+	cl.DevID = 3
+	if _, err := cl.ReadHoldingRegisters(0, 1); err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	if counters := srv.GetCounters(); counters.BusMessages != 2 || counters.ServerMessages != 1 {
+		t.Error("Expected 2 bus messages and 1 server message, got", counters)
+	}
+	srv.SetUnitIDs()
+	cl.DevID = 1
+	if _, err := cl.ReadHoldingRegisters(0, 1); err != nil {
+		t.Error("Expected nil, got", err)
+	}
+	if ids := srv.GetUnitIDs(); ids != nil {
+		t.Error("Expected nil, got", ids)
+	}
+}